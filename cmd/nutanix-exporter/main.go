@@ -17,24 +17,124 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/ingka-group/nutanix-exporter/internal/config"
 	"github.com/ingka-group/nutanix-exporter/internal/exporter"
 )
 
 // main is the entrypoint of the exporter
 func main() {
 
+	// Parse command line flags
+	configPath := defineFlags(flag.CommandLine)
+	flag.Parse()
+
+	// Reloads go through the same file, environment and flag precedence as startup
+	load := func() (*config.Config, error) {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		applyFlags(flag.CommandLine, cfg)
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration in %s:\n%w", *configPath, err)
+		}
+		return cfg, nil
+	}
+
+	cfg, err := load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Every package logs through slog, the level filters all of them
+	level, _ := cfg.Server.Level()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
 	// Initialize exporter
-	go exporter.Init()
+	errCh := make(chan error, 1)
+	go func() { errCh <- exporter.Init(cfg, *configPath, load) }()
+
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
 	// Wait for shutdown signal and stop gracefully
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	defer stop()
-	<-ctx.Done()
-	stop()
+	for {
+		select {
+		case <-ctx.Done():
+			stop()
+			return
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading configuration")
+			if err := exporter.Reload(); err != nil {
+				slog.Error("Failed to reload configuration, keeping the current one", "err", err)
+			}
+		case err := <-errCh:
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// defineFlags defines the command line flags, they take precedence over the environment and the configuration file
+// Returns the path of the configuration file
+func defineFlags(fs *flag.FlagSet) *string {
+	configPath := fs.String("config.file", envOrDefault("EXPORTER_CONFIG", config.DefaultPath), "Path to the exporter configuration file")
+	fs.String("web.listen-address", "", "Address to listen on, overrides server.listen_address")
+	fs.String("log.level", "", "Log level (debug, info, warn or error), overrides server.log_level")
+	fs.String("metrics.config-dir", "", "Directory of the metric config files, overrides server.metrics_dir")
+	fs.Int("shard.index", 0, "Shard served by this replica, overrides sharding.index")
+	fs.Int("shard.count", 0, "Number of replicas sharing the clusters, overrides sharding.count")
+	return configPath
+}
+
+// applyFlags overrides the settings of the flags set on the command line
+func applyFlags(fs *flag.FlagSet, cfg *config.Config) {
+	fs.Visit(func(f *flag.Flag) {
+		value := f.Value.(flag.Getter).Get()
+		switch f.Name {
+		case "web.listen-address":
+			cfg.Server.ListenAddress = value.(string)
+		case "log.level":
+			cfg.Server.LogLevel = value.(string)
+		case "metrics.config-dir":
+			cfg.Server.MetricsDir = value.(string)
+		case "shard.index":
+			cfg.Sharding.Index = value.(int)
+		case "shard.count":
+			cfg.Sharding.Count = value.(int)
+		}
+	})
+}
+
+// loadConfig reads the configuration file and applies the environment overrides
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	// test
+// envOrDefault returns the value of the environment variable or the given default if it is not set
+func envOrDefault(env, def string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return def
 }
@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSettingsPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exporter.yaml")
+	yaml := "server:\n  listen_address: \":9500\"\n  log_level: warn\n  metrics_dir: /etc/file\nsharding:\n  index: 1\n  count: 3\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		args      []string
+		listen    string
+		logLevel  string
+		dir       string
+		shardIdx  int
+		shardSize int
+	}{
+		{
+			name:      "file",
+			listen:    ":9500",
+			logLevel:  "warn",
+			dir:       "/etc/file",
+			shardIdx:  1,
+			shardSize: 3,
+		},
+		{
+			name:      "environment over file",
+			env:       map[string]string{"LISTEN_ADDRESS": ":9600", "SHARD_INDEX": "2"},
+			listen:    ":9600",
+			logLevel:  "warn",
+			dir:       "/etc/file",
+			shardIdx:  2,
+			shardSize: 3,
+		},
+		{
+			name:      "flags over environment",
+			env:       map[string]string{"LISTEN_ADDRESS": ":9600", "LOG_LEVEL": "debug"},
+			args:      []string{"--web.listen-address=:9700", "--metrics.config-dir=/etc/flag", "--shard.count=0"},
+			listen:    ":9700",
+			logLevel:  "debug",
+			dir:       "/etc/flag",
+			shardIdx:  1,
+			shardSize: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for env, value := range tt.env {
+				t.Setenv(env, value)
+			}
+			fs := flag.NewFlagSet("nutanix-exporter", flag.ContinueOnError)
+			defineFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := loadConfig(path)
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			applyFlags(fs, cfg)
+
+			if cfg.Server.ListenAddress != tt.listen {
+				t.Errorf("listen address = %q, want %q", cfg.Server.ListenAddress, tt.listen)
+			}
+			if cfg.Server.LogLevel != tt.logLevel {
+				t.Errorf("log level = %q, want %q", cfg.Server.LogLevel, tt.logLevel)
+			}
+			if cfg.Server.MetricsDir != tt.dir {
+				t.Errorf("metrics dir = %q, want %q", cfg.Server.MetricsDir, tt.dir)
+			}
+			if cfg.Sharding.Index != tt.shardIdx || cfg.Sharding.Count != tt.shardSize {
+				t.Errorf("sharding = %d of %d, want %d of %d", cfg.Sharding.Index, cfg.Sharding.Count, tt.shardIdx, tt.shardSize)
+			}
+		})
+	}
+}
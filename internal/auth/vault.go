@@ -18,9 +18,10 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault-client-go"
@@ -29,89 +30,153 @@ import (
 
 const (
 	Timeout = 30 * time.Second
+
+	// Supported secrets engine types
+	EngineKVv2    = "kv-v2"
+	EngineKVv1    = "kv-v1"
+	EngineLogical = "logical"
 )
 
 var (
 	PCTaskAccount string
 	PETaskAccount string
 	EngineName    string
+	EngineType    string
 )
 
-// VaultClient is a wrapper around the Vault client
-type VaultClient struct {
-	client *vault.Client
+// VaultConfig holds the Vault connection and secret location settings
+type VaultConfig struct {
+	Address       string        `yaml:"address"`
+	RoleID        string        `yaml:"role_id"`
+	SecretID      string        `yaml:"secret_id"`
+	Namespace     string        `yaml:"namespace"`
+	EngineName    string        `yaml:"engine_name"`
+	EngineType    string        `yaml:"engine_type"`     // One of kv-v2, kv-v1 or logical
+	PCTaskAccount string        `yaml:"pc_task_account"` // Secret path of the Prism Central credentials
+	PETaskAccount string        `yaml:"pe_task_account"` // Secret path of the Prism Element credentials
+	WatchInterval time.Duration `yaml:"watch_interval"`  // Poll interval for credential rotations, 0 disables it
 }
 
-// getEnvOrFatal returns the value of the specified environment variable or exits the program
-func getEnvOrFatal(envVar string) string {
-	value := os.Getenv(envVar)
-	if value == "" {
-		log.Fatalf("%s environment variable is not set", envVar)
+// Validate returns an error for every missing or invalid setting
+// pc_task_account is only required with a Prism Central and checked by the exporter configuration
+func (c VaultConfig) Validate() error {
+	var errs []error
+	for _, field := range []struct{ name, value string }{
+		{"address", c.Address},
+		{"role_id", c.RoleID},
+		{"secret_id", c.SecretID},
+		{"namespace", c.Namespace},
+		{"engine_name", c.EngineName},
+		{"pe_task_account", c.PETaskAccount},
+	} {
+		if field.value == "" {
+			errs = append(errs, fmt.Errorf("vault.%s is not set", field.name))
+		}
 	}
-	return value
+	switch c.EngineType {
+	case EngineKVv2, EngineKVv1, EngineLogical:
+	default:
+		errs = append(errs, fmt.Errorf("vault.engine_type %q is not supported, expected one of %s, %s or %s", c.EngineType, EngineKVv2, EngineKVv1, EngineLogical))
+	}
+	if c.WatchInterval < 0 {
+		errs = append(errs, fmt.Errorf("vault.watch_interval must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Credentials holds the secrets used to authenticate against Prism
+type Credentials struct {
+	Username string
+	Password string
+	APIKey   string // Optional v4 API key, used instead of username and password when set
+}
+
+// Valid reports whether the credentials can be used to authenticate
+func (c Credentials) Valid() bool {
+	return c.APIKey != "" || (c.Username != "" && c.Password != "")
+}
+
+// VaultClient is a wrapper around the Vault client
+type VaultClient struct {
+	client *vault.Client
 }
 
 // NewVaultClient creates a new Vault client and authenticates using AppRole
-// Uses the VAULT_ADDR, VAULT_ROLE_ID, VAULT_SECRET_ID and VAULT_NAMESPACE environment variables
-func NewVaultClient() (*VaultClient, error) {
+func NewVaultClient(config VaultConfig) (*VaultClient, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
 	defer cancel()
 
-	addr := getEnvOrFatal("VAULT_ADDR")
-	roleId := getEnvOrFatal("VAULT_ROLE_ID")
-	secretId := getEnvOrFatal("VAULT_SECRET_ID")
-	namespace := getEnvOrFatal("VAULT_NAMESPACE")
-	PETaskAccount = getEnvOrFatal("PE_TASK_ACCOUNT")
-	PCTaskAccount = getEnvOrFatal("PC_TASK_ACCOUNT")
-	EngineName = getEnvOrFatal("VAULT_ENGINE_NAME")
+	PETaskAccount = config.PETaskAccount
+	PCTaskAccount = config.PCTaskAccount
+	EngineName = config.EngineName
+	EngineType = config.EngineType
 
-	log.Printf("Creating new Vault client for %s", addr)
+	slog.Info("Creating new Vault client", "address", config.Address)
 	client, err := vault.New(
-		vault.WithAddress(addr),
+		vault.WithAddress(config.Address),
 		vault.WithRequestTimeout(Timeout),
 	)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	log.Printf("Authenticating with Vault using AppRole")
+	slog.Info("Authenticating with Vault using AppRole")
 	resp, err := client.Auth.AppRoleLogin(
 		ctx,
 		schema.AppRoleLoginRequest{
-			RoleId:   roleId,
-			SecretId: secretId,
+			RoleId:   config.RoleID,
+			SecretId: config.SecretID,
 		},
-		vault.WithNamespace(namespace),
+		vault.WithNamespace(config.Namespace),
 	)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("AppRole login failed: %w", err)
 	}
 
-	log.Printf("Setting token for Vault client")
+	slog.Debug("Setting token for Vault client")
 	if err := client.SetToken(resp.Auth.ClientToken); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	if err = client.SetNamespace(namespace); err != nil {
-		log.Fatal(err)
+	if err = client.SetNamespace(config.Namespace); err != nil {
+		return nil, err
 	}
 
 	return &VaultClient{client: client}, nil
 }
 
-// GetSecret reads a secret from Vault using KV V2 secrets engine
+// GetSecret reads a secret from Vault using the configured secrets engine type
 func (v *VaultClient) GetSecret(path, engine string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
 	defer cancel()
 
-	// Read the secret from the specified path using KV V2
-	vaultResponse, err := v.client.Secrets.KvV2Read(ctx, path, vault.WithMountPath(engine))
-	if err != nil {
-		return "", err
+	var data map[string]interface{}
+	switch EngineType {
+	case EngineKVv1:
+		// KV V1 returns the secret fields directly in the response data
+		vaultResponse, err := v.client.Secrets.KvV1Read(ctx, path, vault.WithMountPath(engine))
+		if err != nil {
+			return "", err
+		}
+		data = vaultResponse.Data
+	case EngineLogical:
+		// Generic logical read for any other engine (e.g. dynamic secrets)
+		vaultResponse, err := v.client.Read(ctx, fmt.Sprintf("%s/%s", strings.Trim(engine, "/"), path))
+		if err != nil {
+			return "", err
+		}
+		data = vaultResponse.Data
+	default:
+		// KV V2 wraps the secret fields in a versioned data object
+		vaultResponse, err := v.client.Secrets.KvV2Read(ctx, path, vault.WithMountPath(engine))
+		if err != nil {
+			return "", err
+		}
+		data = vaultResponse.Data.Data
 	}
 
 	// Marshal the secret data into JSON
-	jsonData, err := json.Marshal(vaultResponse.Data.Data)
+	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return "", fmt.Errorf("error marshalling secret data to JSON: %s", err)
 	}
@@ -119,29 +184,65 @@ func (v *VaultClient) GetSecret(path, engine string) (string, error) {
 	return string(jsonData), nil
 }
 
-// GetPCCreds returns the username and password for the specified Prism Central cluster
-func (v *VaultClient) GetPCCreds(cluster string) (string, string) {
-	return v.GetCreds(cluster, PCTaskAccount, EngineName)
+// GetSecretVersion returns the current version of a KV V2 secret from its metadata
+func (v *VaultClient) GetSecretVersion(path, engine string) (int64, error) {
+	if EngineType != EngineKVv2 {
+		return 0, fmt.Errorf("secret versions are only available for %s engines", EngineKVv2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	vaultResponse, err := v.client.Secrets.KvV2ReadMetadata(ctx, path, vault.WithMountPath(engine))
+	if err != nil {
+		return 0, err
+	}
+
+	return vaultResponse.Data.CurrentVersion, nil
 }
 
-// GetPECreds returns the username and password for the specified Prism Element cluster
-func (v *VaultClient) GetPECreds(cluster string) (string, string) {
-	return v.GetCreds(cluster, PETaskAccount, EngineName)
+// GetPCCredsVersion returns the current secret version for the specified Prism Central cluster
+func (v *VaultClient) GetPCCredsVersion(cluster string) (int64, error) {
+	return v.GetSecretVersion(fmt.Sprintf("%s/%s", cluster, PCTaskAccount), EngineName)
 }
 
-// GetCreds returns the username and password for the specified cluster, path, and engine
-func (v *VaultClient) GetCreds(cluster, path, engine string) (string, string) {
+// GetPECredsVersion returns the current secret version for the specified Prism Element cluster
+func (v *VaultClient) GetPECredsVersion(cluster string) (int64, error) {
+	return v.GetSecretVersion(fmt.Sprintf("%s/%s", cluster, PETaskAccount), EngineName)
+}
+
+// ReadPCCreds returns the credentials for the specified Prism Central cluster or an error
+func (v *VaultClient) ReadPCCreds(cluster string) (Credentials, error) {
+	return v.ReadCreds(cluster, PCTaskAccount, EngineName)
+}
+
+// ReadPECreds returns the credentials for the specified Prism Element cluster or an error
+func (v *VaultClient) ReadPECreds(cluster string) (Credentials, error) {
+	return v.ReadCreds(cluster, PETaskAccount, EngineName)
+}
+
+// ReadCreds returns the credentials for the specified cluster, path, and engine
+func (v *VaultClient) ReadCreds(cluster, path, engine string) (Credentials, error) {
 	secrets, err := v.GetSecret(fmt.Sprintf("%s/%s", cluster, path), engine)
 	if err != nil {
-		log.Fatalf("Failed to get secrets for %s: %v", cluster, err)
+		return Credentials{}, fmt.Errorf("failed to get secrets for %s: %w", cluster, err)
 	}
 
 	var vaultSecret struct {
 		Username string `json:"username"`
 		Secret   string `json:"secret"`
+		Password string `json:"password"` // Used by most dynamic secrets engines
+		APIKey   string `json:"api_key"`
 	}
 	if err := json.Unmarshal([]byte(secrets), &vaultSecret); err != nil {
-		log.Fatalf("Failed to parse secrets for %s: %v", cluster, err)
+		return Credentials{}, fmt.Errorf("failed to parse secrets for %s: %w", cluster, err)
+	}
+	if vaultSecret.Secret == "" {
+		vaultSecret.Secret = vaultSecret.Password
 	}
-	return vaultSecret.Username, vaultSecret.Secret
+	return Credentials{
+		Username: vaultSecret.Username,
+		Password: vaultSecret.Secret,
+		APIKey:   vaultSecret.APIKey,
+	}, nil
 }
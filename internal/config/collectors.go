@@ -0,0 +1,135 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CollectorConfig holds the settings of a single collector
+// Unset fields fall back to the global settings and then to the defaults
+type CollectorConfig struct {
+	Enabled    *bool         `yaml:"enabled"`
+	Timeout    time.Duration `yaml:"timeout"` // Upper bound for the collector, the scrape timeout still applies
+	ConfigPath string        `yaml:"config"`  // Metric config file, relative paths are resolved against the metric config directory
+}
+
+// IsEnabled reports whether the collector is enabled, collectors are enabled unless disabled explicitly
+func (c CollectorConfig) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// merge returns the settings with the fields set in override replacing its own
+func (c CollectorConfig) merge(override CollectorConfig) CollectorConfig {
+	if override.Enabled != nil {
+		c.Enabled = override.Enabled
+	}
+	if override.Timeout > 0 {
+		c.Timeout = override.Timeout
+	}
+	if override.ConfigPath != "" {
+		c.ConfigPath = override.ConfigPath
+	}
+	return c
+}
+
+// DefaultCollectors returns the default settings of every available collector, keyed by collector name
+func DefaultCollectors() map[string]CollectorConfig {
+	return map[string]CollectorConfig{
+		"cluster":           {Timeout: 10 * time.Second, ConfigPath: "cluster.yaml"},
+		"host":              {Timeout: 10 * time.Second, ConfigPath: "host.yaml"},
+		"vm":                {Timeout: 10 * time.Second, ConfigPath: "vm.yaml"},
+		"storage_container": {Timeout: 10 * time.Second, ConfigPath: "storage_container.yaml"},
+	}
+}
+
+// CollectorsFor returns the resolved settings of every available collector for the named cluster
+func (c *Config) CollectorsFor(name string) map[string]CollectorConfig {
+	collectors := DefaultCollectors()
+	for collector, settings := range collectors {
+		settings = settings.merge(c.Collectors[collector])
+		if cluster, ok := c.Clusters[name]; ok {
+			settings = settings.merge(cluster.Collectors[collector])
+		}
+		if !filepath.IsAbs(settings.ConfigPath) {
+			settings.ConfigPath = filepath.Join(c.Server.MetricsDir, settings.ConfigPath)
+		}
+		collectors[collector] = settings
+	}
+	return collectors
+}
+
+// EnabledCollectors returns the sorted names of the collectors enabled for the named cluster
+func (c *Config) EnabledCollectors(name string) []string {
+	var names []string
+	for collector, settings := range c.CollectorsFor(name) {
+		if settings.IsEnabled() {
+			names = append(names, collector)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MaxCollectorTimeout returns the largest collector timeout configured for any cluster
+func (c *Config) MaxCollectorTimeout() time.Duration {
+	var longest time.Duration
+	names := []string{""} // Clusters without overrides
+	for name := range c.Clusters {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		for _, settings := range c.CollectorsFor(name) {
+			longest = max(longest, settings.Timeout)
+		}
+	}
+	return longest
+}
+
+// validateCollectors returns an error if a collector section or module references an unknown collector
+func (c *Config) validateCollectors() error {
+	known := DefaultCollectors()
+	check := func(section, collector string) error {
+		if _, ok := known[collector]; !ok {
+			return fmt.Errorf("unknown collector %q in %s", collector, section)
+		}
+		return nil
+	}
+
+	for collector := range c.Collectors {
+		if err := check("collectors", collector); err != nil {
+			return err
+		}
+	}
+	for name, cluster := range c.Clusters {
+		for collector := range cluster.Collectors {
+			if err := check(fmt.Sprintf("clusters.%s.collectors", name), collector); err != nil {
+				return err
+			}
+		}
+	}
+	for name, module := range c.Modules {
+		for _, collector := range module.Collectors {
+			if err := check(fmt.Sprintf("modules.%s", name), collector); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollectorsFor(t *testing.T) {
+	disabled := false
+	cfg := Default()
+	cfg.Server.MetricsDir = "/etc/nutanix"
+	cfg.Collectors = map[string]CollectorConfig{
+		"vm":   {Timeout: 20 * time.Second},
+		"host": {ConfigPath: "/opt/host.yaml"},
+	}
+	cfg.Clusters = map[string]ClusterConfig{
+		"lab": {Collectors: map[string]CollectorConfig{
+			"vm":                {Enabled: &disabled},
+			"storage_container": {Timeout: 30 * time.Second, ConfigPath: "containers.yaml"},
+		}},
+	}
+
+	tests := []struct {
+		cluster   string
+		collector string
+		want      CollectorConfig
+	}{
+		{"prod", "cluster", CollectorConfig{Timeout: 10 * time.Second, ConfigPath: "/etc/nutanix/cluster.yaml"}},
+		{"prod", "vm", CollectorConfig{Timeout: 20 * time.Second, ConfigPath: "/etc/nutanix/vm.yaml"}},
+		{"prod", "host", CollectorConfig{Timeout: 10 * time.Second, ConfigPath: "/opt/host.yaml"}},
+		{"lab", "vm", CollectorConfig{Enabled: &disabled, Timeout: 20 * time.Second, ConfigPath: "/etc/nutanix/vm.yaml"}},
+		{"lab", "storage_container", CollectorConfig{Timeout: 30 * time.Second, ConfigPath: "/etc/nutanix/containers.yaml"}},
+	}
+	for _, tt := range tests {
+		if got := cfg.CollectorsFor(tt.cluster)[tt.collector]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CollectorsFor(%q)[%q] = %+v, want %+v", tt.cluster, tt.collector, got, tt.want)
+		}
+	}
+
+	if got, want := cfg.EnabledCollectors("lab"), []string{"cluster", "host", "storage_container"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EnabledCollectors(lab) = %v, want %v", got, want)
+	}
+	if got := cfg.MaxCollectorTimeout(); got != 30*time.Second {
+		t.Errorf("MaxCollectorTimeout = %v, want 30s", got)
+	}
+}
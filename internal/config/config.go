@@ -0,0 +1,344 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/auth"
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultPath          = "configs/exporter.yaml"
+	DefaultListenAddress = ":9408"
+	DefaultMetricsDir    = "configs"
+)
+
+// Config represents the exporter configuration file
+type Config struct {
+	Server           ServerConfig               `yaml:"server"`
+	PrismCentral     PrismCentralConfig         `yaml:"prism_central"`   // Set through the PC_* environment variables
+	PrismCentrals    []PrismCentralConfig       `yaml:"prism_centrals"`  // Additional Prism Central instances
+	NameConflicts    string                     `yaml:"name_conflicts"`  // prefix or skip clusters reported by several Prism Centrals
+	ClusterFilters   ClusterFilter              `yaml:"cluster_filters"` // Applied to the clusters of every Prism Central
+	Sharding         ShardingConfig             `yaml:"sharding"`
+	Vault            auth.VaultConfig           `yaml:"vault"`
+	Transport        nutanix.TransportConfig    `yaml:"transport"`
+	TLS              nutanix.TLSConfig          `yaml:"tls"` // Global TLS settings
+	Retry            nutanix.RetryConfig        `yaml:"retry"`
+	Breaker          nutanix.BreakerConfig      `yaml:"circuit_breaker"`
+	RateLimit        nutanix.RateLimitConfig    `yaml:"rate_limit"` // Global limits, applied to each Prism instance
+	Proxy            nutanix.ProxyConfig        `yaml:"proxy"`
+	Dedup            DedupConfig                `yaml:"dedup"`
+	Collectors       map[string]CollectorConfig `yaml:"collectors"` // Global collector settings, keyed by collector name
+	Modules          map[string]ModuleConfig    `yaml:"modules"`    // Collector sets for the /probe endpoint, keyed by module name
+	Probe            ProbeConfig                `yaml:"probe"`
+	ServiceDiscovery ServiceDiscoveryConfig     `yaml:"service_discovery"`
+	Clusters         map[string]ClusterConfig   `yaml:"clusters"` // Static clusters and per cluster overrides, keyed by cluster name
+}
+
+// ServerConfig holds the settings of the exporter process
+type ServerConfig struct {
+	ListenAddress  string        `yaml:"listen_address"`
+	LogLevel       string        `yaml:"log_level"`       // One of debug, info, warn or error
+	MetricsDir     string        `yaml:"metrics_dir"`     // Directory of the metric config files, relative collector configs are resolved against it
+	ReloadInterval time.Duration `yaml:"reload_interval"` // Poll interval for changes to the configuration files, 0 disables it
+}
+
+// PrismCentralConfig holds the Prism Central instance used to discover clusters
+// Discovery is disabled when no URL is set
+type PrismCentralConfig struct {
+	Name          string        `yaml:"name"`
+	URL           string        `yaml:"url"`
+	APIVersion    string        `yaml:"api_version"`    // v3 or v4, defaults to v4
+	ClusterPrefix string        `yaml:"cluster_prefix"` // Only clusters whose name starts with the prefix are scraped
+	Credentials   string        `yaml:"credentials"`    // Vault secret holding the credentials, defaults to the name
+	Filters       ClusterFilter `yaml:"filters"`        // Applied in addition to the global cluster_filters
+}
+
+// Name conflict policies for clusters reported by several Prism Centrals
+// The first Prism Central in configuration order keeps the plain cluster name
+const (
+	NameConflictPrefix = "prefix" // Later clusters are named <pc_name>-<cluster_name>
+	NameConflictSkip   = "skip"   // Later clusters are not scraped
+)
+
+// ShardingConfig splits the clusters across exporter replicas by consistent hashing of their name
+type ShardingConfig struct {
+	Index int `yaml:"index"` // Shard of this replica, from 0 to count - 1
+	Count int `yaml:"count"` // Number of replicas, 0 or 1 disables sharding
+}
+
+// Enabled reports whether the clusters are split across replicas
+func (s ShardingConfig) Enabled() bool {
+	return s.Count > 1
+}
+
+// DedupConfig holds the settings for sharing Prism calls between concurrent scrapes
+type DedupConfig struct {
+	MaxAge time.Duration `yaml:"max_age"` // Reuse results younger than this, 0 only shares in-flight calls
+}
+
+// ServiceDiscoveryConfig holds the settings of the Prometheus file_sd output
+type ServiceDiscoveryConfig struct {
+	File   string `yaml:"file"`   // file_sd JSON file written at startup, empty disables it
+	Target string `yaml:"target"` // Exporter address listed as target, defaults to the host name and listen port
+}
+
+// ModuleConfig holds the collectors run by a /probe module
+type ModuleConfig struct {
+	Collectors  []string `yaml:"collectors"`  // Empty runs every collector
+	Credentials string   `yaml:"credentials"` // Vault secret for targets that are not served clusters, defaults to the target host name
+}
+
+// ProbeConfig holds the settings of the /probe endpoint
+type ProbeConfig struct {
+	AllowedTargets []string `yaml:"allowed_targets"` // Anchored expressions for the hosts of targets that are not served clusters, empty rejects them
+}
+
+// Allows reports whether a temporary client may be built for the target host
+func (p ProbeConfig) Allows(host string) bool {
+	for _, expr := range p.AllowedTargets {
+		if re, err := anchored(expr); err == nil && re != nil && re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClusterConfig holds the settings that can be overridden for a single cluster
+// A cluster with a URL is scraped even if Prism Central does not report it
+type ClusterConfig struct {
+	URL         string                     `yaml:"url"`         // Prism Element URL of a static cluster
+	Credentials string                     `yaml:"credentials"` // Vault secret holding the credentials, defaults to the cluster name
+	Labels      map[string]string          `yaml:"labels"`      // Target labels of a static cluster
+	TLS         *nutanix.TLSConfig         `yaml:"tls"`         // Replaces the global TLS settings when set
+	RateLimit   *nutanix.RateLimitConfig   `yaml:"rate_limit"`  // Replaces the global limits when set
+	Proxy       *nutanix.ProxyConfig       `yaml:"proxy"`       // Replaces the global proxy when set
+	Collectors  map[string]CollectorConfig `yaml:"collectors"`  // Merged over the global collector settings
+}
+
+// Default returns the configuration used when no file is present
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddress: DefaultListenAddress,
+			LogLevel:      "info",
+			MetricsDir:    DefaultMetricsDir,
+		},
+		PrismCentral:  PrismCentralConfig{APIVersion: "v4"},
+		NameConflicts: NameConflictPrefix,
+		Vault:         auth.VaultConfig{EngineType: auth.EngineKVv2},
+		Transport:     nutanix.DefaultTransportConfig(),
+		Retry:         nutanix.DefaultRetryConfig(),
+		Breaker:       nutanix.DefaultBreakerConfig(),
+	}
+}
+
+// TLSFor returns the TLS settings for the named cluster
+func (c *Config) TLSFor(name string) nutanix.TLSConfig {
+	if cluster, ok := c.Clusters[name]; ok && cluster.TLS != nil {
+		return *cluster.TLS
+	}
+	return c.TLS
+}
+
+// RateLimitFor returns the rate limit settings for the named cluster
+func (c *Config) RateLimitFor(name string) nutanix.RateLimitConfig {
+	if cluster, ok := c.Clusters[name]; ok && cluster.RateLimit != nil {
+		return *cluster.RateLimit
+	}
+	return c.RateLimit
+}
+
+// ProxyFor returns the proxy settings for the named cluster
+func (c *Config) ProxyFor(name string) nutanix.ProxyConfig {
+	if cluster, ok := c.Clusters[name]; ok && cluster.Proxy != nil {
+		return *cluster.Proxy
+	}
+	return c.Proxy
+}
+
+// StaticClusters returns the sorted names of the clusters defined with a URL
+func (c *Config) StaticClusters() []string {
+	var names []string
+	for name, cluster := range c.Clusters {
+		if cluster.URL != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PrismCentralList returns every configured Prism Central in configuration order
+// The instance set with prism_central comes first
+func (c *Config) PrismCentralList() []PrismCentralConfig {
+	var list []PrismCentralConfig
+	if c.PrismCentral.Name != "" || c.PrismCentral.URL != "" {
+		list = append(list, c.PrismCentral)
+	}
+	for _, pc := range c.PrismCentrals {
+		if pc.APIVersion == "" {
+			pc.APIVersion = "v4"
+		}
+		list = append(list, pc)
+	}
+	return list
+}
+
+// SecretFor returns the Vault secret holding the credentials of the named cluster
+func (c *Config) SecretFor(name string) string {
+	if cluster, ok := c.Clusters[name]; ok && cluster.Credentials != "" {
+		return cluster.Credentials
+	}
+	return name
+}
+
+// Load reads the configuration file at the given path on top of the defaults
+// A missing file is not an error and yields the default configuration
+// The result is not validated, callers apply their overrides and call Validate
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	yamlFile, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(yamlFile, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Level returns the configured log level
+func (s ServerConfig) Level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s.LogLevel)); err != nil {
+		return level, fmt.Errorf("server.log_level %q is not supported, expected one of debug, info, warn or error", s.LogLevel)
+	}
+	return level, nil
+}
+
+// Validate returns an error listing every missing or invalid setting
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Server.ListenAddress); err != nil {
+		errs = append(errs, fmt.Errorf("server.listen_address %q is invalid: %w", c.Server.ListenAddress, err))
+	}
+	if _, err := c.Server.Level(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Server.ReloadInterval < 0 {
+		errs = append(errs, errors.New("server.reload_interval must not be negative"))
+	}
+
+	seen := make(map[string]bool)
+	validatePC := func(section string, pc PrismCentralConfig) {
+		if pc.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name is not set", section))
+		} else if seen[pc.Name] {
+			errs = append(errs, fmt.Errorf("%s.name %q is used by another Prism Central", section, pc.Name))
+		}
+		seen[pc.Name] = true
+		if !validURL(pc.URL) {
+			errs = append(errs, fmt.Errorf("%s.url %q is invalid, expected a URL such as https://host:9440", section, pc.URL))
+		}
+		if pc.APIVersion != "" && pc.APIVersion != "v3" && pc.APIVersion != "v4" {
+			errs = append(errs, fmt.Errorf("%s.api_version %q is not supported, expected v3 or v4", section, pc.APIVersion))
+		}
+	}
+	if pc := c.PrismCentral; pc.Name != "" || pc.URL != "" {
+		validatePC("prism_central", pc)
+	}
+	for i, pc := range c.PrismCentrals {
+		validatePC(fmt.Sprintf("prism_centrals[%d]", i), pc)
+	}
+	if _, err := c.ClusterFilters.compile("cluster_filters"); err != nil {
+		errs = append(errs, err)
+	}
+	for _, pc := range c.PrismCentralList() {
+		if _, err := pc.Filters.compile(fmt.Sprintf("filters of Prism Central %s", pc.Name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(seen) == 0 && len(c.StaticClusters()) == 0 {
+		errs = append(errs, errors.New("no clusters to scrape, set prism_central or define clusters with a url"))
+	}
+	for i, expr := range c.Probe.AllowedTargets {
+		if expr == "" {
+			errs = append(errs, fmt.Errorf("probe.allowed_targets[%d] is empty", i))
+		} else if _, err := anchored(expr); err != nil {
+			errs = append(errs, fmt.Errorf("probe.allowed_targets[%d]: invalid expression: %w", i, err))
+		}
+	}
+	if c.Sharding.Count < 0 {
+		errs = append(errs, fmt.Errorf("sharding.count must not be negative"))
+	} else if c.Sharding.Index < 0 || (c.Sharding.Count > 0 && c.Sharding.Index >= c.Sharding.Count) {
+		errs = append(errs, fmt.Errorf("sharding.index %d is out of range for %d shards", c.Sharding.Index, c.Sharding.Count))
+	}
+	if c.NameConflicts != NameConflictPrefix && c.NameConflicts != NameConflictSkip {
+		errs = append(errs, fmt.Errorf("name_conflicts %q is not supported, expected %s or %s", c.NameConflicts, NameConflictPrefix, NameConflictSkip))
+	}
+	for _, name := range c.StaticClusters() {
+		if !validURL(c.Clusters[name].URL) {
+			errs = append(errs, fmt.Errorf("clusters.%s.url %q is invalid, expected a URL such as https://host:9440", name, c.Clusters[name].URL))
+		}
+		for label := range c.Clusters[name].Labels {
+			if !labelName.MatchString(label) || strings.HasPrefix(label, "__") || label == "cluster_name" {
+				errs = append(errs, fmt.Errorf("clusters.%s.labels: invalid label name %q", name, label))
+			}
+		}
+	}
+
+	if err := c.Vault.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(c.PrismCentralList()) > 0 && c.Vault.PCTaskAccount == "" {
+		errs = append(errs, errors.New("vault.pc_task_account is not set, it is required with a Prism Central"))
+	}
+	if err := c.validateCollectors(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// labelName matches valid Prometheus label names
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validURL reports whether the string is an absolute URL with a host
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Host != ""
+}
@@ -0,0 +1,284 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration that passes Validate
+func validConfig() *Config {
+	cfg := Default()
+	cfg.PrismCentral.Name = "pc"
+	cfg.PrismCentral.URL = "https://pc.example.com:9440"
+	cfg.Vault.Address = "https://vault.example.com"
+	cfg.Vault.RoleID = "role"
+	cfg.Vault.SecretID = "secret"
+	cfg.Vault.Namespace = "ns"
+	cfg.Vault.EngineName = "kv"
+	cfg.Vault.PCTaskAccount = "pc-account"
+	cfg.Vault.PETaskAccount = "pe-account"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   []string // Substrings of the error, none means valid
+	}{
+		{
+			name:   "valid",
+			modify: func(*Config) {},
+		},
+		{
+			name: "static clusters only",
+			modify: func(c *Config) {
+				c.PrismCentral = PrismCentralConfig{}
+				c.Vault.PCTaskAccount = ""
+				c.Clusters = map[string]ClusterConfig{"lab": {URL: "https://lab:9440", Labels: map[string]string{"site": "lab"}}}
+			},
+		},
+		{
+			name:   "Prism Central without its Vault account",
+			modify: func(c *Config) { c.Vault.PCTaskAccount = "" },
+			want:   []string{"vault.pc_task_account is not set"},
+		},
+		{
+			name:   "invalid listen address",
+			modify: func(c *Config) { c.Server.ListenAddress = "9408" },
+			want:   []string{"server.listen_address"},
+		},
+		{
+			name:   "unknown log level",
+			modify: func(c *Config) { c.Server.LogLevel = "verbose" },
+			want:   []string{"server.log_level"},
+		},
+		{
+			name:   "negative reload interval",
+			modify: func(c *Config) { c.Server.ReloadInterval = -time.Second },
+			want:   []string{"server.reload_interval"},
+		},
+		{
+			name:   "no clusters",
+			modify: func(c *Config) { c.PrismCentral = PrismCentralConfig{} },
+			want:   []string{"no clusters to scrape"},
+		},
+		{
+			name: "duplicate Prism Central name",
+			modify: func(c *Config) {
+				c.PrismCentrals = []PrismCentralConfig{{Name: "pc", URL: "https://other:9440"}}
+			},
+			want: []string{`prism_centrals[0].name "pc" is used by another Prism Central`},
+		},
+		{
+			name: "invalid Prism Central",
+			modify: func(c *Config) {
+				c.PrismCentrals = []PrismCentralConfig{{URL: "pc2:9440", APIVersion: "v2"}}
+			},
+			want: []string{"prism_centrals[0].name is not set", "prism_centrals[0].url", "prism_centrals[0].api_version"},
+		},
+		{
+			name: "invalid static cluster",
+			modify: func(c *Config) {
+				c.Clusters = map[string]ClusterConfig{"lab": {URL: "lab", Labels: map[string]string{"cluster_name": "x", "1site": "y"}}}
+			},
+			want: []string{"clusters.lab.url", `invalid label name "cluster_name"`, `invalid label name "1site"`},
+		},
+		{
+			name:   "shard index out of range",
+			modify: func(c *Config) { c.Sharding = ShardingConfig{Index: 3, Count: 3} },
+			want:   []string{"sharding.index 3 is out of range for 3 shards"},
+		},
+		{
+			name:   "negative shard count",
+			modify: func(c *Config) { c.Sharding.Count = -1 },
+			want:   []string{"sharding.count must not be negative"},
+		},
+		{
+			name:   "unknown name conflict strategy",
+			modify: func(c *Config) { c.NameConflicts = "rename" },
+			want:   []string{"name_conflicts"},
+		},
+		{
+			name:   "vault incomplete",
+			modify: func(c *Config) { c.Vault.RoleID = ""; c.Vault.EngineType = "kv-v3" },
+			want:   []string{"vault.role_id is not set", "vault.engine_type"},
+		},
+		{
+			name:   "unknown collector",
+			modify: func(c *Config) { c.Collectors = map[string]CollectorConfig{"disk": {}} },
+			want:   []string{`unknown collector "disk" in collectors`},
+		},
+		{
+			name: "invalid filter",
+			modify: func(c *Config) {
+				c.PrismCentral.Filters.Include = []ClusterMatcher{{UUID: "["}}
+			},
+			want: []string{"filters of Prism Central pc.include[0]: invalid uuid expression"},
+		},
+		{
+			name:   "invalid probe target expression",
+			modify: func(c *Config) { c.Probe.AllowedTargets = []string{"edge-(", ""} },
+			want:   []string{"probe.allowed_targets[0]: invalid expression", "probe.allowed_targets[1] is empty"},
+		},
+		{
+			name: "every problem is reported",
+			modify: func(c *Config) {
+				c.Server.LogLevel = "verbose"
+				c.NameConflicts = "rename"
+				c.Vault.Address = ""
+			},
+			want: []string{"server.log_level", "name_conflicts", "vault.address is not set"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate = nil, want errors containing %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := Load(filepath.Join(dir, "missing.yaml"))
+	if err != nil {
+		t.Fatalf("Load of a missing file: %v", err)
+	}
+	if cfg.Server.ListenAddress != DefaultListenAddress || cfg.NameConflicts != NameConflictPrefix {
+		t.Errorf("Load of a missing file = %+v, want the defaults", cfg.Server)
+	}
+
+	path := filepath.Join(dir, "exporter.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  log_level: debug\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Server.LogLevel != "debug" || cfg.Server.ListenAddress != DefaultListenAddress {
+		t.Errorf("Load = %+v, want log level debug on top of the defaults", cfg.Server)
+	}
+
+	if err := os.WriteFile(path, []byte("server: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load of invalid YAML = nil, want an error")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+		check   func(*Config) bool
+	}{
+		{
+			name:  "strings",
+			env:   map[string]string{"PC_CLUSTER_URL": "https://env:9440", "VAULT_ENGINE_TYPE": "kv-v1"},
+			check: func(c *Config) bool { return c.PrismCentral.URL == "https://env:9440" && c.Vault.EngineType == "kv-v1" },
+		},
+		{
+			name:  "empty values are ignored",
+			env:   map[string]string{"LOG_LEVEL": ""},
+			check: func(c *Config) bool { return c.Server.LogLevel == "info" },
+		},
+		{
+			name: "durations and numbers",
+			env:  map[string]string{"VAULT_WATCH_INTERVAL": "5m", "SHARD_INDEX": "1", "SHARD_COUNT": "4"},
+			check: func(c *Config) bool {
+				return c.Vault.WatchInterval == 5*time.Minute && c.Sharding == ShardingConfig{Index: 1, Count: 4}
+			},
+		},
+		{
+			name:    "invalid duration",
+			env:     map[string]string{"VAULT_WATCH_INTERVAL": "5"},
+			wantErr: "invalid VAULT_WATCH_INTERVAL",
+		},
+		{
+			name:    "invalid number",
+			env:     map[string]string{"SHARD_COUNT": "two"},
+			wantErr: "invalid SHARD_COUNT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for env, value := range tt.env {
+				t.Setenv(env, value)
+			}
+			cfg := Default()
+			err := cfg.ApplyEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ApplyEnv = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyEnv: %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("ApplyEnv did not apply %v", tt.env)
+			}
+		})
+	}
+}
+
+func TestProbeAllows(t *testing.T) {
+	probe := ProbeConfig{AllowedTargets: []string{`ntnx-edge-[0-9]+\.example\.com`, `10\.1\.2\..*`}}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"ntnx-edge-042.example.com", true},
+		{"ntnx-edge-042.example.com.attacker.net", false},
+		{"ntnx-edge-x.example.com", false},
+		{"10.1.2.30", true},
+		{"110.1.2.30", false},
+	}
+	for _, tt := range tests {
+		if got := probe.Allows(tt.host); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+	if (ProbeConfig{}).Allows("ntnx-edge-042.example.com") {
+		t.Error("an empty allow-list allows targets")
+	}
+}
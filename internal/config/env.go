@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ApplyEnv overrides the settings for which an environment variable is set
+func (c *Config) ApplyEnv() error {
+	for env, field := range map[string]*string{
+		"LISTEN_ADDRESS":     &c.Server.ListenAddress,
+		"LOG_LEVEL":          &c.Server.LogLevel,
+		"METRICS_CONFIG_DIR": &c.Server.MetricsDir,
+		"PC_CLUSTER_NAME":    &c.PrismCentral.Name,
+		"PC_CLUSTER_URL":     &c.PrismCentral.URL,
+		"PC_API_VERSION":     &c.PrismCentral.APIVersion,
+		"CLUSTER_PREFIX":     &c.PrismCentral.ClusterPrefix,
+		"VAULT_ADDR":         &c.Vault.Address,
+		"VAULT_ROLE_ID":      &c.Vault.RoleID,
+		"VAULT_SECRET_ID":    &c.Vault.SecretID,
+		"VAULT_NAMESPACE":    &c.Vault.Namespace,
+		"VAULT_ENGINE_NAME":  &c.Vault.EngineName,
+		"VAULT_ENGINE_TYPE":  &c.Vault.EngineType,
+		"PC_TASK_ACCOUNT":    &c.Vault.PCTaskAccount,
+		"PE_TASK_ACCOUNT":    &c.Vault.PETaskAccount,
+	} {
+		if value := os.Getenv(env); value != "" {
+			*field = value
+		}
+	}
+
+	if value := os.Getenv("VAULT_WATCH_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid VAULT_WATCH_INTERVAL %q: %w", value, err)
+		}
+		c.Vault.WatchInterval = interval
+	}
+
+	for env, field := range map[string]*int{
+		"SHARD_INDEX": &c.Sharding.Index,
+		"SHARD_COUNT": &c.Sharding.Count,
+	} {
+		if value := os.Getenv(env); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %w", env, value, err)
+			}
+			*field = n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ClusterMatcher selects clusters by regular expressions, every field set must match
+// Expressions are anchored, so "prod-.*" matches names starting with prod-
+type ClusterMatcher struct {
+	Name       string            `yaml:"name"`
+	UUID       string            `yaml:"uuid"`
+	AOSVersion string            `yaml:"aos_version"`
+	Hypervisor string            `yaml:"hypervisor"`
+	Categories map[string]string `yaml:"categories"` // Prism Central category name to value expression, empty only requires the category
+}
+
+// ClusterFilter holds the include and exclude lists applied to clusters discovered through Prism Central
+// Static clusters defined with a url are always scraped and bypass the filters
+// A cluster is scraped if it matches any include matcher, or there are none, and no exclude matcher
+type ClusterFilter struct {
+	Include []ClusterMatcher `yaml:"include"`
+	Exclude []ClusterMatcher `yaml:"exclude"`
+}
+
+// ClusterAttributes holds the cluster details matched by a filter
+type ClusterAttributes struct {
+	Name       string
+	UUID       string
+	AOSVersion string
+	Hypervisor string
+	Categories map[string]string
+}
+
+// compiledMatcher is a ClusterMatcher with its expressions compiled, nil fields match anything
+type compiledMatcher struct {
+	name, uuid, aosVersion, hypervisor *regexp.Regexp
+	categories                         map[string]*regexp.Regexp
+}
+
+// compiledFilter is a ClusterFilter with its expressions compiled
+type compiledFilter struct {
+	include, exclude []compiledMatcher
+}
+
+// ClusterSelector decides which discovered clusters are scraped
+type ClusterSelector struct {
+	filters []compiledFilter // Every filter must accept the cluster
+}
+
+// anchored compiles the expression so it has to match the whole value, empty expressions match anything
+func anchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// compile returns the matcher with its expressions compiled
+func (m ClusterMatcher) compile() (compiledMatcher, error) {
+	var c compiledMatcher
+	var err error
+	for _, field := range []struct {
+		name string
+		expr string
+		re   **regexp.Regexp
+	}{
+		{"name", m.Name, &c.name},
+		{"uuid", m.UUID, &c.uuid},
+		{"aos_version", m.AOSVersion, &c.aosVersion},
+		{"hypervisor", m.Hypervisor, &c.hypervisor},
+	} {
+		if *field.re, err = anchored(field.expr); err != nil {
+			return c, fmt.Errorf("invalid %s expression: %w", field.name, err)
+		}
+	}
+	if len(m.Categories) > 0 {
+		c.categories = make(map[string]*regexp.Regexp, len(m.Categories))
+		for category, expr := range m.Categories {
+			if c.categories[category], err = anchored(expr); err != nil {
+				return c, fmt.Errorf("invalid expression for category %s: %w", category, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// match reports whether the cluster matches every expression of the matcher
+func (m compiledMatcher) match(attrs ClusterAttributes) bool {
+	for _, field := range []struct {
+		re    *regexp.Regexp
+		value string
+	}{
+		{m.name, attrs.Name},
+		{m.uuid, attrs.UUID},
+		{m.aosVersion, attrs.AOSVersion},
+		{m.hypervisor, attrs.Hypervisor},
+	} {
+		if field.re != nil && !field.re.MatchString(field.value) {
+			return false
+		}
+	}
+	for category, re := range m.categories {
+		value, ok := attrs.Categories[category]
+		if !ok || (re != nil && !re.MatchString(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compile returns the filter with its expressions compiled
+func (f ClusterFilter) compile(section string) (compiledFilter, error) {
+	var c compiledFilter
+	for i, m := range f.Include {
+		matcher, err := m.compile()
+		if err != nil {
+			return c, fmt.Errorf("%s.include[%d]: %w", section, i, err)
+		}
+		c.include = append(c.include, matcher)
+	}
+	for i, m := range f.Exclude {
+		matcher, err := m.compile()
+		if err != nil {
+			return c, fmt.Errorf("%s.exclude[%d]: %w", section, i, err)
+		}
+		c.exclude = append(c.exclude, matcher)
+	}
+	return c, nil
+}
+
+// accept reports whether the filter lets the cluster through
+func (f compiledFilter) accept(attrs ClusterAttributes) bool {
+	for _, m := range f.exclude {
+		if m.match(attrs) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, m := range f.include {
+		if m.match(attrs) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesCategories reports whether any matcher of the filter needs the cluster categories
+func (f ClusterFilter) usesCategories() bool {
+	for _, m := range f.Include {
+		if len(m.Categories) > 0 {
+			return true
+		}
+	}
+	for _, m := range f.Exclude {
+		if len(m.Categories) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the cluster is scraped
+func (s *ClusterSelector) Match(attrs ClusterAttributes) bool {
+	for _, f := range s.filters {
+		if !f.accept(attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectorFor returns the cluster selector of the Prism Central, combining the global
+// cluster_filters, the filters of the Prism Central and its cluster prefix
+func (c *Config) SelectorFor(pc PrismCentralConfig) (*ClusterSelector, error) {
+	global, err := c.ClusterFilters.compile("cluster_filters")
+	if err != nil {
+		return nil, err
+	}
+	local, err := pc.Filters.compile(fmt.Sprintf("filters of Prism Central %s", pc.Name))
+	if err != nil {
+		return nil, err
+	}
+	selector := &ClusterSelector{filters: []compiledFilter{global, local}}
+	if pc.ClusterPrefix != "" {
+		prefix, _ := ClusterMatcher{Name: regexp.QuoteMeta(pc.ClusterPrefix) + ".*"}.compile()
+		selector.filters = append(selector.filters, compiledFilter{include: []compiledMatcher{prefix}})
+	}
+	return selector, nil
+}
+
+// NeedsCategories reports whether the clusters of the Prism Central are filtered by category
+func (c *Config) NeedsCategories(pc PrismCentralConfig) bool {
+	return c.ClusterFilters.usesCategories() || pc.Filters.usesCategories()
+}
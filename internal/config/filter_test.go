@@ -0,0 +1,190 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnchored(t *testing.T) {
+	tests := []struct {
+		expr  string
+		value string
+		want  bool
+	}{
+		{"", "anything", true},
+		{"prod", "prod", true},
+		{"prod", "prod-01", false},
+		{"prod", "preprod", false},
+		{"prod-.*", "prod-01", true},
+		{"prod-.*", "lab-prod-01", false},
+		{"a|b", "a", true},
+		{"a|b", "ab", false},
+		{"6\\.7\\..*", "6.7.1", true},
+		{"6\\.7\\..*", "6.10", false},
+	}
+	for _, tt := range tests {
+		re, err := anchored(tt.expr)
+		if err != nil {
+			t.Fatalf("anchored(%q): %v", tt.expr, err)
+		}
+		if got := re == nil || re.MatchString(tt.value); got != tt.want {
+			t.Errorf("anchored(%q) matching %q = %v, want %v", tt.expr, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestClusterSelector(t *testing.T) {
+	prod := ClusterAttributes{
+		Name:       "prod-01",
+		UUID:       "0005-aaaa",
+		AOSVersion: "6.7.1",
+		Hypervisor: "AHV",
+		Categories: map[string]string{"Environment": "Production"},
+	}
+	lab := ClusterAttributes{
+		Name:       "lab-01",
+		UUID:       "0005-bbbb",
+		AOSVersion: "6.5.2",
+		Hypervisor: "ESX",
+	}
+
+	tests := []struct {
+		name   string
+		global ClusterFilter
+		pc     PrismCentralConfig
+		attrs  ClusterAttributes
+		want   bool
+	}{
+		{
+			name:  "no filters",
+			attrs: lab,
+			want:  true,
+		},
+		{
+			name:   "include by name",
+			global: ClusterFilter{Include: []ClusterMatcher{{Name: "prod-.*"}}},
+			attrs:  prod,
+			want:   true,
+		},
+		{
+			name:   "not included",
+			global: ClusterFilter{Include: []ClusterMatcher{{Name: "prod-.*"}}},
+			attrs:  lab,
+			want:   false,
+		},
+		{
+			name:   "any include matches",
+			global: ClusterFilter{Include: []ClusterMatcher{{Name: "prod-.*"}, {Hypervisor: "ESX"}}},
+			attrs:  lab,
+			want:   true,
+		},
+		{
+			name:   "every field of a matcher must match",
+			global: ClusterFilter{Include: []ClusterMatcher{{Name: "prod-.*", Hypervisor: "ESX"}}},
+			attrs:  prod,
+			want:   false,
+		},
+		{
+			name: "exclude wins over include",
+			global: ClusterFilter{
+				Include: []ClusterMatcher{{Name: ".*-01"}},
+				Exclude: []ClusterMatcher{{AOSVersion: "6\\.5\\..*"}},
+			},
+			attrs: lab,
+			want:  false,
+		},
+		{
+			name:   "category value",
+			global: ClusterFilter{Include: []ClusterMatcher{{Categories: map[string]string{"Environment": "Prod.*"}}}},
+			attrs:  prod,
+			want:   true,
+		},
+		{
+			name:   "empty category value requires the category",
+			global: ClusterFilter{Exclude: []ClusterMatcher{{Categories: map[string]string{"Environment": ""}}}},
+			attrs:  lab,
+			want:   true,
+		},
+		{
+			name:   "missing category",
+			global: ClusterFilter{Include: []ClusterMatcher{{Categories: map[string]string{"Environment": ".*"}}}},
+			attrs:  lab,
+			want:   false,
+		},
+		{
+			name:   "global and Prism Central filters both apply",
+			global: ClusterFilter{Include: []ClusterMatcher{{Name: "prod-.*"}}},
+			pc:     PrismCentralConfig{Filters: ClusterFilter{Exclude: []ClusterMatcher{{Hypervisor: "AHV"}}}},
+			attrs:  prod,
+			want:   false,
+		},
+		{
+			name:  "cluster prefix",
+			pc:    PrismCentralConfig{ClusterPrefix: "prod-"},
+			attrs: prod,
+			want:  true,
+		},
+		{
+			name:  "cluster prefix is literal",
+			pc:    PrismCentralConfig{ClusterPrefix: "prod."},
+			attrs: ClusterAttributes{Name: "prod-01"},
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{ClusterFilters: tt.global}
+			selector, err := cfg.SelectorFor(tt.pc)
+			if err != nil {
+				t.Fatalf("SelectorFor: %v", err)
+			}
+			if got := selector.Match(tt.attrs); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectorForInvalidExpression(t *testing.T) {
+	cfg := &Config{ClusterFilters: ClusterFilter{Exclude: []ClusterMatcher{{Name: "prod-("}}}}
+	_, err := cfg.SelectorFor(PrismCentralConfig{Name: "pc"})
+	if err == nil || !strings.Contains(err.Error(), "cluster_filters.exclude[0]: invalid name expression") {
+		t.Errorf("SelectorFor error = %v, want invalid name expression in cluster_filters.exclude[0]", err)
+	}
+}
+
+func TestNeedsCategories(t *testing.T) {
+	byCategory := ClusterFilter{Include: []ClusterMatcher{{Categories: map[string]string{"Environment": ""}}}}
+	tests := []struct {
+		name   string
+		global ClusterFilter
+		pc     ClusterFilter
+		want   bool
+	}{
+		{"no filters", ClusterFilter{}, ClusterFilter{}, false},
+		{"name only", ClusterFilter{Exclude: []ClusterMatcher{{Name: "lab-.*"}}}, ClusterFilter{}, false},
+		{"global categories", byCategory, ClusterFilter{}, true},
+		{"Prism Central categories", ClusterFilter{}, byCategory, true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{ClusterFilters: tt.global}
+		if got := cfg.NeedsCategories(PrismCentralConfig{Filters: tt.pc}); got != tt.want {
+			t.Errorf("%s: NeedsCategories = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
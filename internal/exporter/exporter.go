@@ -18,119 +18,362 @@ package exporter
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"strings"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ingka-group/nutanix-exporter/internal/auth"
+	"github.com/ingka-group/nutanix-exporter/internal/config"
 	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
 	"github.com/ingka-group/nutanix-exporter/internal/prom"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
-	ListenAddress  = ":9408"
-	DefaultSection = "default"
+	DefaultSection      = "default"
+	ScrapeTimeoutOffset = 500 * time.Millisecond // Time left to encode and send the response
+	CategoryTimeout     = 60 * time.Second       // Bound for looking up the v4 categories of all clusters
 )
 
 var (
-	ClusterPrefix string
-	PCApiVersion  string
 	VaultClient   *auth.VaultClient
+	PrismCentrals map[string]*nutanix.Cluster // Keyed by Prism Central name
 	ClustersMap   map[string]*nutanix.Cluster
+
+	currentConfig atomic.Pointer[config.Config] // Replaced on reload
 )
 
-func Init() {
+// Config returns the current configuration
+func Config() *config.Config {
+	return currentConfig.Load()
+}
 
-	// Get environment variables
-	PCClusterName := getEnvOrFatal("PC_CLUSTER_NAME")
-	PCClusterURL := getEnvOrFatal("PC_CLUSTER_URL")
-	PCApiVersion := os.Getenv("PC_API_VERSION") // Optional, defaults to v3
-	if PCApiVersion == "" {
-		PCApiVersion = "v4"
+// Init connects to Vault and the Prism Centrals, sets up the clusters and serves the metrics
+// The configuration must have been validated; Init only returns once the server stops
+// load is called to read the configuration again on reload, configPath is watched for changes
+func Init(cfg *config.Config, configPath string, load Loader) error {
+	currentConfig.Store(cfg)
+	if err := validateMetricConfigs(cfg); err != nil {
+		return err
 	}
-	ClusterPrefix = os.Getenv("CLUSTER_PREFIX") // Optional
+	prom.ResultMaxAge = cfg.Dedup.MaxAge
 
-	log.Printf("Initializing Vault client")
-	vaultClient, err := auth.NewVaultClient()
+	slog.Info("Initializing Vault client")
+	vaultClient, err := auth.NewVaultClient(cfg.Vault)
 	if err != nil {
-		log.Fatalf("Failed to create Vault client: %v", err)
+		return fmt.Errorf("failed to create Vault client: %w", err)
 	}
 
-	log.Printf("Connecting to Prism Central")
-	PCCluster := nutanix.NewCluster(PCClusterName, PCClusterURL, vaultClient, true, true, 10*time.Second)
-	if PCCluster == nil {
-		log.Fatalf("Failed to connect to Prism Central cluster")
+	// Discover clusters through every Prism Central, in configuration order
+	pcMap := make(map[string]*nutanix.Cluster)
+	clusterMap := make(map[string]*nutanix.Cluster)
+	for _, pc := range cfg.PrismCentralList() {
+		slog.Info("Connecting to Prism Central", "pc", pc.Name)
+		secret := pc.Credentials
+		if secret == "" {
+			secret = cfg.SecretFor(pc.Name)
+		}
+		PCCluster := nutanix.NewCluster(pc.Name, pc.URL, secret, vaultClient, true, clientOptions(pc.Name))
+		if PCCluster == nil {
+			slog.Error("Failed to connect to Prism Central, skipping its clusters", "pc", pc.Name)
+			continue
+		}
+		PCCluster.Labels = map[string]string{"pc_name": pc.Name}
+		pcMap[pc.Name] = PCCluster
+
+		slog.Info("Initializing clusters of Prism Central", "pc", pc.Name)
+		if err := SetupClusters(PCCluster, vaultClient, pc, clusterMap); err != nil {
+			slog.Error("Failed to initialize clusters of Prism Central", "pc", pc.Name, "err", err)
+		}
 	}
 
-	log.Printf("Initializing clusters")
-	clusterMap, err := SetupClusters(PCCluster, vaultClient, PCApiVersion)
-	if err != nil {
-		log.Fatalf("Failed to initialize clusters: %v", err)
+	// Discovery skips the clusters defined as static clusters, so no discovered client is replaced
+	for name, cluster := range SetupStaticClusters(vaultClient) {
+		clusterMap[name] = cluster
+	}
+	if len(pcMap) == 0 && len(clusterMap) == 0 {
+		return errors.New("no Prism Central could be reached and no static cluster could be initialized")
 	}
 
-	log.Printf("Initializing HTTP server")
+	// Optionally watch Vault for credential rotations
+	if watchInterval := cfg.Vault.WatchInterval; watchInterval > 0 {
+		if auth.EngineType != auth.EngineKVv2 {
+			slog.Warn("Credential watch requires a kv-v2 engine, skipping", "engine_type", auth.EngineType)
+		} else {
+			slog.Info("Watching Vault for credential rotations", "interval", watchInterval)
+			for _, pc := range pcMap {
+				go pc.WatchCredentials(context.Background(), vaultClient, watchInterval)
+			}
+			for _, cluster := range clusterMap {
+				go cluster.WatchCredentials(context.Background(), vaultClient, watchInterval)
+			}
+		}
+	}
+
+	VaultClient, PrismCentrals, ClustersMap = vaultClient, pcMap, clusterMap
+	reload.setLoader(configPath, load)
+	updateShardMetrics(len(clusterMap))
+	if Config().Sharding.Enabled() {
+		slog.Info("Serving shard", "index", Config().Sharding.Index, "count", Config().Sharding.Count, "clusters", len(clusterMap))
+	}
+
+	slog.Info("Initializing HTTP server")
 	http.HandleFunc("/", indexHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/probe", probeHandler)
+	http.HandleFunc("/sd", sdHandler)
+	http.HandleFunc("/-/reload", reloadHandler)
+
+	if interval := cfg.Server.ReloadInterval; interval > 0 {
+		slog.Info("Watching the configuration and metric configs for changes", "path", configPath, "interval", interval)
+		go reload.watch(context.Background(), interval)
+	}
+
+	if path := Config().ServiceDiscovery.File; path != "" {
+		if err := writeFileSD(path); err != nil {
+			slog.Error("Failed to write file_sd targets", "path", path, "err", err)
+		} else {
+			slog.Info("Wrote file_sd targets", "path", path)
+		}
+	}
 
 	for name, cluster := range clusterMap {
 		route := fmt.Sprintf("/metrics/%s", name)
 		http.HandleFunc(route, createClusterMetricsHandler(cluster, vaultClient))
-		log.Printf("Registered metrics endpoint for cluster %s at %s", name, route)
+		slog.Debug("Registered metrics endpoint", "cluster", name, "route", route)
 	}
 
-	log.Printf("Starting Server on %s", ListenAddress)
-	if err := http.ListenAndServe(ListenAddress, nil); err != nil {
-		log.Fatalf("Error starting server: %s", err)
+	slog.Info("Starting server", "address", cfg.Server.ListenAddress)
+	if err := http.ListenAndServe(cfg.Server.ListenAddress, nil); err != nil {
+		return fmt.Errorf("error starting server: %w", err)
 	}
+	return nil
 }
 
 // SetupClusters creates Prometheus collectors for every cluster registered in Prism Central
-func SetupClusters(prismClient *nutanix.Cluster, vaultClient *auth.VaultClient, PCApiVersion string) (map[string]*nutanix.Cluster, error) {
-	clusterData, err := FetchClusters(prismClient, PCApiVersion)
+// and adds them to clustersMap, names already taken by another Prism Central are resolved
+// according to the name_conflicts setting
+func SetupClusters(prismClient *nutanix.Cluster, vaultClient *auth.VaultClient, pc config.PrismCentralConfig, clustersMap map[string]*nutanix.Cluster) error {
+	selector, err := Config().SelectorFor(pc)
 	if err != nil {
-		return nil, err // Propagate the error up
+		return err
+	}
+	clusterData, err := FetchClusters(prismClient, pc.APIVersion, selector, Config().NeedsCategories(pc))
+	if err != nil {
+		return err // Propagate the error up
 	}
 
-	clustersMap := make(map[string]*nutanix.Cluster)
-	for name, url := range clusterData {
-		cluster := nutanix.NewCluster(name, url, vaultClient, false, true, 10*time.Second)
+	for original, info := range clusterData {
+		secret := Config().SecretFor(original)
+		name, ok := servedName(Config(), original, pc.Name, clustersMap)
+		if !ok {
+			continue
+		}
+		if !ownsCluster(name) {
+			slog.Debug("Skipping cluster owned by another shard", "cluster", name, "shard", shardOf(name, Config().Sharding.Count))
+			continue
+		}
+
+		cluster := nutanix.NewCluster(name, info.URL, secret, vaultClient, false, clientOptions(name))
 		if cluster == nil {
-			log.Printf("Failed to initialize cluster %s", name)
+			slog.Error("Failed to initialize cluster", "cluster", name)
 			continue
 		}
+		cluster.Labels = map[string]string{
+			"pc_name":      prismClient.Name,
+			"cluster_uuid": info.UUID,
+			"hypervisor":   info.Hypervisor,
+			"aos_version":  info.Version,
+		}
 
 		// Register collectors for this cluster
-		log.Printf("Registering collectors for cluster %s", name)
-		collectors := []prometheus.Collector{
-			prom.NewStorageContainerCollector(cluster, "configs/storage_container.yaml"),
-			prom.NewClusterCollector(cluster, "configs/cluster.yaml"),
-			prom.NewHostCollector(cluster, "configs/host.yaml"),
-			prom.NewVMCollector(cluster, "configs/vm.yaml"),
-		}
+		slog.Info("Registering collectors", "cluster", name, "collectors", Config().EnabledCollectors(name))
+		cluster.SetCollectors([]prometheus.Collector{NewScrapeCollector(cluster, nil)})
 
-		for _, collector := range collectors {
-			cluster.Registry.MustRegister(collector)
+		// Add the cluster to the map
+		clustersMap[name] = cluster
+	}
+
+	return nil
+}
+
+// servedName returns the name a cluster discovered through the Prism Central is served under
+// Returns false if the cluster is not served: it is defined as a static cluster, which replaces it,
+// or its name is taken and name_conflicts is skip or the prefixed name is taken as well
+func servedName(cfg *config.Config, name, pcName string, taken map[string]*nutanix.Cluster) (string, bool) {
+	if cfg.Clusters[name].URL != "" {
+		slog.Info("Cluster is defined as a static cluster, using the static definition", "cluster", name, "pc", pcName)
+		return "", false
+	}
+	if _, ok := taken[name]; !ok {
+		return name, true
+	}
+	if cfg.NameConflicts == config.NameConflictSkip {
+		slog.Warn("Cluster is already reported by another Prism Central, skipping", "cluster", name, "pc", pcName)
+		return "", false
+	}
+	renamed := fmt.Sprintf("%s-%s", pcName, name)
+	if _, ok := taken[renamed]; ok || cfg.Clusters[renamed].URL != "" {
+		slog.Warn("Cluster conflicts with another cluster, skipping", "cluster", name, "pc", pcName, "conflict", renamed)
+		return "", false
+	}
+	slog.Warn("Cluster is already reported by another Prism Central, renaming it", "cluster", name, "pc", pcName, "name", renamed)
+	return renamed, true
+}
+
+// SetupStaticClusters creates Prometheus collectors for every cluster defined with a URL in the configuration
+// Clusters that cannot be initialized are logged and skipped
+func SetupStaticClusters(vaultClient *auth.VaultClient) map[string]*nutanix.Cluster {
+	clustersMap := make(map[string]*nutanix.Cluster)
+	for _, name := range Config().StaticClusters() {
+		if !ownsCluster(name) {
+			slog.Debug("Skipping static cluster owned by another shard", "cluster", name, "shard", shardOf(name, Config().Sharding.Count))
+			continue
+		}
+		settings := Config().Clusters[name]
+		cluster := nutanix.NewCluster(name, settings.URL, Config().SecretFor(name), vaultClient, false, clientOptions(name))
+		if cluster == nil {
+			slog.Error("Failed to initialize static cluster", "cluster", name)
+			continue
 		}
-		cluster.Collectors = collectors
+		cluster.Labels = settings.Labels
 
-		// Add the cluster to the map
+		slog.Info("Registering collectors for static cluster", "cluster", name, "collectors", Config().EnabledCollectors(name))
+		cluster.SetCollectors([]prometheus.Collector{NewScrapeCollector(cluster, nil)})
 		clustersMap[name] = cluster
 	}
+	return clustersMap
+}
+
+// collectorFactories holds the constructor of every available collector, keyed by collector name
+var collectorFactories = map[string]func(cluster *nutanix.Cluster, configPath string) prom.Collector{
+	"storage_container": func(c *nutanix.Cluster, path string) prom.Collector {
+		return prom.NewStorageContainerCollector(c, path)
+	},
+	"cluster": func(c *nutanix.Cluster, path string) prom.Collector { return prom.NewClusterCollector(c, path) },
+	"host":    func(c *nutanix.Cluster, path string) prom.Collector { return prom.NewHostCollector(c, path) },
+	"vm":      func(c *nutanix.Cluster, path string) prom.Collector { return prom.NewVMCollector(c, path) },
+}
+
+// NewScrapeCollector returns a scrape collector running the named collectors for the cluster
+// The collectors enabled for the cluster are used if no names are given
+// Collectors are run by a single scrape collector reporting their status; it is registered
+// per scrape so collection is bound to the scrape request
+func NewScrapeCollector(cluster *nutanix.Cluster, names []string) *prom.ScrapeCollector {
+	return newScrapeCollector(Config(), cluster, names)
+}
+
+// newScrapeCollector returns a scrape collector for the cluster using the collector settings of cfg
+func newScrapeCollector(cfg *config.Config, cluster *nutanix.Cluster, names []string) *prom.ScrapeCollector {
+	if len(names) == 0 {
+		names = cfg.EnabledCollectors(cluster.Name)
+	}
+
+	settings := cfg.CollectorsFor(cluster.Name)
+	collectors := make(map[string]prom.Collector, len(names))
+	timeouts := make(map[string]time.Duration, len(names))
+	for _, name := range names {
+		factory, ok := collectorFactories[name]
+		if !ok {
+			continue
+		}
+		collectors[name] = factory(cluster, settings[name].ConfigPath)
+		timeouts[name] = settings[name].Timeout
+	}
 
-	return clustersMap, nil
+	scrape := prom.NewScrapeCollector(cluster, collectors)
+	scrape.Timeouts = timeouts
+	return scrape
+}
+
+// ClusterInfo holds the details of a Prism Element cluster registered in Prism Central
+type ClusterInfo struct {
+	Name       string
+	URL        string
+	UUID       string
+	Hypervisor string
+	Version    string            // AOS version
+	Categories map[string]string // Prism Central categories, resolved for v4 only when filtered on
+}
+
+// nestedString returns the string at the given path of nested maps, or "" if it does not exist
+func nestedString(m map[string]interface{}, keys ...string) string {
+	for i, key := range keys {
+		if i == len(keys)-1 {
+			value, _ := m[key].(string)
+			return value
+		}
+		m, _ = m[key].(map[string]interface{})
+	}
+	return ""
+}
+
+// attributes returns the cluster details matched by the cluster filters
+func (c ClusterInfo) attributes() config.ClusterAttributes {
+	return config.ClusterAttributes{
+		Name:       c.Name,
+		UUID:       c.UUID,
+		AOSVersion: c.Version,
+		Hypervisor: c.Hypervisor,
+		Categories: c.Categories,
+	}
+}
+
+// fetchV4Categories returns the key and value of every category defined in Prism Central, keyed by extId
+func fetchV4Categories(ctx context.Context, prismClient *nutanix.Cluster) (map[string][2]string, error) {
+	const pageSize = 100
+
+	categories := make(map[string][2]string)
+	for page := 0; ; page++ {
+		resp, err := prismClient.API.MakeRequest(ctx, "GET", fmt.Sprintf("/api/prism/v4.0.b1/config/categories?$page=%d&$limit=%d", page, pageSize))
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Data []struct {
+				ExtID string `json:"extId"`
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"data"`
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			err = fmt.Errorf("failed to list categories: %s", resp.Status)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, category := range result.Data {
+			categories[category.ExtID] = [2]string{category.Key, category.Value}
+		}
+		if len(result.Data) < pageSize {
+			return categories, nil
+		}
+	}
 }
 
-// FetchClusters fetches the name and IP of all Prism Element clusters registered in Prism Central.
-// Takes a version flag to switch between v3 and v4 API calls. Skips clusters that don't match the prefix if provided.
-func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]string, error) {
+// FetchClusters fetches the name, IP and details of all Prism Element clusters registered in Prism Central.
+// Takes a version flag to switch between v3 and v4 API calls. Skips clusters rejected by the selector.
+// v4 category names and values are only looked up if withCategories is set.
+func FetchClusters(prismClient *nutanix.Cluster, version string, selector *config.ClusterSelector, withCategories bool) (map[string]ClusterInfo, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clusterData := make(map[string]string)
+	clusterData := make(map[string]ClusterInfo)
 
 	// Define the functions for making requests and parsing for both v3 and v4.
 
@@ -152,13 +395,25 @@ func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]str
 	}
 
 	// v4 parsing function
-	parseV4Clusters := func(result map[string]interface{}) ([]map[string]string, error) {
+	parseV4Clusters := func(result map[string]interface{}) ([]ClusterInfo, error) {
 		data, ok := result["data"].([]interface{})
 		if !ok {
 			return nil, fmt.Errorf("unexpected response format for v4")
 		}
 
-		var clusters []map[string]string
+		var categories map[string][2]string
+		if withCategories {
+			var err error
+			// Paging through every category can take longer than listing the clusters
+			categoryCtx, cancel := context.WithTimeout(context.Background(), CategoryTimeout)
+			categories, err = fetchV4Categories(categoryCtx, prismClient)
+			cancel()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var clusters []ClusterInfo
 		for _, cluster := range data {
 			clusterMap := cluster.(map[string]interface{})
 			name, nameOk := clusterMap["name"].(string)
@@ -174,22 +429,44 @@ func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]str
 				continue
 			}
 
-			clusters = append(clusters, map[string]string{
-				"name": name,
-				"ip":   ip,
+			hypervisor := ""
+			if config, ok := clusterMap["config"].(map[string]interface{}); ok {
+				if types, ok := config["hypervisorTypes"].([]interface{}); ok && len(types) > 0 {
+					hypervisor, _ = types[0].(string)
+				}
+			}
+
+			clusterCategories := make(map[string]string)
+			if extIDs, ok := clusterMap["categories"].([]interface{}); ok {
+				for _, extID := range extIDs {
+					if id, ok := extID.(string); ok {
+						if category, ok := categories[id]; ok {
+							clusterCategories[category[0]] = category[1]
+						}
+					}
+				}
+			}
+
+			clusters = append(clusters, ClusterInfo{
+				Name:       name,
+				URL:        fmt.Sprintf("https://%s:9440", ip),
+				UUID:       nestedString(clusterMap, "extId"),
+				Hypervisor: hypervisor,
+				Version:    nestedString(clusterMap, "config", "buildInfo", "version"),
+				Categories: clusterCategories,
 			})
 		}
 		return clusters, nil
 	}
 
 	// v3 parsing function
-	parseV3Clusters := func(result map[string]interface{}) ([]map[string]string, error) {
+	parseV3Clusters := func(result map[string]interface{}) ([]ClusterInfo, error) {
 		entities, ok := result["entities"].([]interface{})
 		if !ok {
 			return nil, fmt.Errorf("unexpected response format for v3")
 		}
 
-		var clusters []map[string]string
+		var clusters []ClusterInfo
 		for _, entity := range entities {
 			cluster := entity.(map[string]interface{})
 			spec, specOk := cluster["spec"].(map[string]interface{})
@@ -213,9 +490,32 @@ func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]str
 				continue
 			}
 
-			clusters = append(clusters, map[string]string{
-				"name": name,
-				"ip":   ip,
+			hypervisor := ""
+			if resources, ok := status["resources"].(map[string]interface{}); ok {
+				if nodes, ok := resources["nodes"].(map[string]interface{}); ok {
+					if servers, ok := nodes["hypervisor_server_list"].([]interface{}); ok && len(servers) > 0 {
+						if server, ok := servers[0].(map[string]interface{}); ok {
+							hypervisor, _ = server["type"].(string)
+						}
+					}
+				}
+			}
+
+			metadata, _ := cluster["metadata"].(map[string]interface{})
+			clusterCategories := make(map[string]string)
+			if categories, ok := metadata["categories"].(map[string]interface{}); ok {
+				for key, value := range categories {
+					clusterCategories[key], _ = value.(string)
+				}
+			}
+
+			clusters = append(clusters, ClusterInfo{
+				Name:       name,
+				URL:        fmt.Sprintf("https://%s:9440", ip),
+				UUID:       nestedString(metadata, "uuid"),
+				Hypervisor: hypervisor,
+				Version:    nestedString(status, "resources", "config", "build", "version"),
+				Categories: clusterCategories,
 			})
 		}
 		return clusters, nil
@@ -224,7 +524,7 @@ func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]str
 	// Decide which request and parsing functions to use based on the version
 	var resp *http.Response
 	var err error
-	var parseClusters func(map[string]interface{}) ([]map[string]string, error)
+	var parseClusters func(map[string]interface{}) ([]ClusterInfo, error)
 
 	if version == "v3" {
 		resp, err = makeV3Request()
@@ -252,43 +552,255 @@ func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]str
 
 	// Build the final clusterData map
 	for _, cluster := range clusters {
-		name := cluster["name"]
-		ip := cluster["ip"]
-
-		// Skip clusters that don't match the prefix if provided
-		if ClusterPrefix != "" && !strings.HasPrefix(name, ClusterPrefix) {
-			log.Printf("Skipping cluster %s", name)
+		// Skip clusters rejected by the include and exclude filters
+		if !selector.Match(cluster.attributes()) {
+			slog.Debug("Skipping cluster rejected by the cluster filters", "cluster", cluster.Name)
 			continue
 		}
 
-		clusterData[name] = fmt.Sprintf("https://%s:9440", ip)
-		log.Printf("Found cluster %s at %s", name, clusterData[name])
+		clusterData[cluster.Name] = cluster
+		slog.Info("Found cluster", "cluster", cluster.Name, "url", cluster.URL)
 	}
 
 	return clusterData, nil
 }
 
+// clientOptions returns the Prism API client options for the named cluster from the loaded configuration
+func clientOptions(name string) nutanix.ClientOptions {
+	// No client timeout, requests are bounded by the scrape deadline and the collector timeouts
+	return nutanix.ClientOptions{
+		Transport: Config().Transport,
+		TLS:       Config().TLSFor(name),
+		Retry:     Config().Retry,
+		Breaker:   Config().Breaker,
+		RateLimit: Config().RateLimitFor(name),
+		Proxy:     Config().ProxyFor(name),
+	}
+}
+
 // createClusterMetricsHandler returns a http.HandlerFunc that serves metrics for a specific cluster
 func createClusterMetricsHandler(cluster *nutanix.Cluster, vaultClient *auth.VaultClient) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Refresh credentials for the specific cluster
 		cluster.RefreshCredentialsIfNeeded(vaultClient)
 
-		// Serve metrics from the specific cluster's registry
-		promhttp.HandlerFor(cluster.Registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+		collect, err := collectParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		collectors := cluster.Collectors()
+		if _, disabled := splitCollectors(collectorNames(collectors), collect); len(disabled) > 0 {
+			http.Error(w, fmt.Sprintf("collectors %v are disabled for cluster %s", disabled, cluster.Name), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+
+		gatherer, err := clusterGatherer(ctx, cluster, collectors, collect)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Serve metrics from the specific cluster's registry and the scrape collectors
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 	}
 }
 
-// indexHandler handles the / endpoint
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, `<html><head><title>Nutanix Exporter</title></head><body><h1>Nutanix Exporter</h1><p><a href="/metrics">Metrics</a></p></body></html>`)
+// metricsHandler serves the combined metrics of every cluster and the exporter's own runtime metrics
+// Clusters can be filtered with one or more ?cluster= query parameters and collectors with ?collect[]=
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	collect, err := collectParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	clusters := make(map[string]*nutanix.Cluster, len(ClustersMap)+len(PrismCentrals))
+	for name, cluster := range PrismCentrals {
+		// Every replica discovers through each Prism Central, but only one serves its metrics
+		if ownsCluster(name) {
+			clusters[name] = cluster
+		}
+	}
+	for name, cluster := range ClustersMap {
+		clusters[name] = cluster
+	}
+	if names := r.URL.Query()["cluster"]; len(names) > 0 {
+		filtered := make(map[string]*nutanix.Cluster, len(names))
+		for _, name := range names {
+			cluster, ok := clusters[name]
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown cluster %q", name), http.StatusNotFound)
+				return
+			}
+			filtered[name] = cluster
+		}
+		clusters = filtered
+	}
+
+	// Clusters with none of the requested collectors enabled are left out, the others run the enabled ones
+	selected := make(map[*nutanix.Cluster][]string, len(clusters))
+	collectorsOf := make(map[*nutanix.Cluster][]prometheus.Collector, len(clusters))
+	for cluster, collectors := range currentCollectors(clusters) {
+		if run, _ := splitCollectors(collectorNames(collectors), collect); len(collect) == 0 || len(run) > 0 {
+			selected[cluster] = run
+			collectorsOf[cluster] = collectors
+		}
+	}
+	if len(collect) > 0 && len(selected) == 0 && len(clusters) > 0 {
+		http.Error(w, fmt.Sprintf("collectors %v are disabled for every cluster", collect), http.StatusBadRequest)
+		return
+	}
+
+	// Gather the clusters concurrently, the combined gatherer only merges the results
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer}
+	for cluster, run := range selected {
+		wg.Add(1)
+		go func(cluster *nutanix.Cluster, collectors []prometheus.Collector, run []string) {
+			defer wg.Done()
+			cluster.RefreshCredentialsIfNeeded(VaultClient)
+
+			gatherer, err := clusterGatherer(ctx, cluster, collectors, run)
+			if err != nil {
+				slog.Error("Failed to gather metrics", "cluster", cluster.Name, "err", err)
+				return
+			}
+			families, err := gatherer.Gather()
+
+			mu.Lock()
+			gatherers = append(gatherers, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				return families, err
+			}))
+			mu.Unlock()
+		}(cluster, collectorsOf[cluster], run)
+	}
+	wg.Wait()
+
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}).ServeHTTP(w, r)
+}
+
+// collectParam returns the collectors selected with ?collect[]= query parameters, nil selects all of them
+func collectParam(r *http.Request) ([]string, error) {
+	collect := r.URL.Query()["collect[]"]
+	for _, name := range collect {
+		if _, ok := collectorFactories[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return collect, nil
+}
+
+// collectorNames returns the names of the collectors run by the scrape collectors of a cluster
+func collectorNames(collectors []prometheus.Collector) []string {
+	var names []string
+	for _, collector := range collectors {
+		if scrape, ok := collector.(*prom.ScrapeCollector); ok {
+			for name := range scrape.Collectors {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitCollectors splits the requested collectors into the enabled and the disabled ones
+func splitCollectors(enabled, collect []string) (run, disabled []string) {
+	for _, name := range collect {
+		if slices.Contains(enabled, name) {
+			run = append(run, name)
+		} else {
+			disabled = append(disabled, name)
+		}
+	}
+	return run, disabled
+}
+
+// clusterGatherer returns the gatherer for a cluster's registry and its collectors
+// The collectors are bound to the scrape context so abandoned scrapes cancel in-flight Prism calls
+// Only the collectors named in collect are run, unless it is empty
+func clusterGatherer(ctx context.Context, cluster *nutanix.Cluster, collectors []prometheus.Collector, collect []string) (prometheus.Gatherer, error) {
+	registry := prometheus.NewRegistry()
+	for _, collector := range collectors {
+		if scrape, ok := collector.(*prom.ScrapeCollector); ok {
+			if len(collect) > 0 {
+				scrape = scrape.Filter(collect)
+			}
+			collector = scrape.WithContext(ctx)
+		}
+		if err := registry.Register(collector); err != nil {
+			return nil, fmt.Errorf("failed to register collectors: %w", err)
+		}
+	}
+	gatherers := prometheus.Gatherers{cluster.Registry, registry}
+	if pcName := cluster.Labels["pc_name"]; pcName != "" {
+		return labelGatherer{Gatherer: gatherers, labels: map[string]string{"pc_name": pcName}}, nil
+	}
+	return gatherers, nil
+}
+
+// labelGatherer adds constant labels to every series gathered, keeping labels already set
+type labelGatherer struct {
+	prometheus.Gatherer
+	labels map[string]string
+}
+
+// Gather returns the metric families of the wrapped gatherer with the extra labels
+func (g labelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			for name, value := range g.labels {
+				if !hasLabel(metric, name) {
+					metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+				}
+			}
+			sort.Slice(metric.Label, func(i, j int) bool { return metric.Label[i].GetName() < metric.Label[j].GetName() })
+		}
+	}
+	return families, err
 }
 
-// getEnvOrFatal returns the value of the specified environment variable or exits
-func getEnvOrFatal(envVar string) string {
-	value := os.Getenv(envVar)
-	if value == "" {
-		log.Fatalf("%s environment variable is not set", envVar)
+// hasLabel reports whether the metric has a label with the given name
+func hasLabel(metric *dto.Metric, name string) bool {
+	for _, label := range metric.Label {
+		if label.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scrapeContext returns a context derived from the scrape request
+// It honours the X-Prometheus-Scrape-Timeout-Seconds header, leaving ScrapeTimeoutOffset to send the response
+// Without the header the longest collector timeout applies, so it is never cut short by the default
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := max(prom.DefaultTimeout, Config().MaxCollectorTimeout())
+	if header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); header != "" {
+		seconds, err := strconv.ParseFloat(header, 64)
+		if err != nil {
+			slog.Warn("Invalid X-Prometheus-Scrape-Timeout-Seconds header", "header", header, "err", err)
+		} else if scrapeTimeout := time.Duration(seconds*float64(time.Second)) - ScrapeTimeoutOffset; scrapeTimeout > 0 {
+			timeout = scrapeTimeout
+		}
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// indexHandler handles the / endpoint
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	shard := "Sharding disabled"
+	if sharding := Config().Sharding; sharding.Enabled() {
+		shard = fmt.Sprintf("Shard %d of %d", sharding.Index, sharding.Count)
 	}
-	return value
+	fmt.Fprintf(w, `<html><head><title>Nutanix Exporter</title></head><body><h1>Nutanix Exporter</h1><p><a href="/metrics">Metrics</a></p><p>%s, serving %d clusters</p></body></html>`, shard, len(ClustersMap))
 }
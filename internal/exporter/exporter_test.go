@@ -0,0 +1,184 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ingka-group/nutanix-exporter/internal/config"
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSplitCollectors(t *testing.T) {
+	enabled := []string{"cluster", "host", "storage_container"}
+	tests := []struct {
+		collect      []string
+		wantRun      []string
+		wantDisabled []string
+	}{
+		{nil, nil, nil},
+		{[]string{"host"}, []string{"host"}, nil},
+		{[]string{"vm"}, nil, []string{"vm"}},
+		{[]string{"vm", "cluster"}, []string{"cluster"}, []string{"vm"}},
+	}
+	for _, tt := range tests {
+		run, disabled := splitCollectors(enabled, tt.collect)
+		if !reflect.DeepEqual(run, tt.wantRun) || !reflect.DeepEqual(disabled, tt.wantDisabled) {
+			t.Errorf("splitCollectors(%v) = %v, %v, want %v, %v", tt.collect, run, disabled, tt.wantRun, tt.wantDisabled)
+		}
+	}
+}
+
+func TestDisabledCollectorRequests(t *testing.T) {
+	defer func(cfg *config.Config, clusters map[string]*nutanix.Cluster) {
+		currentConfig.Store(cfg)
+		ClustersMap = clusters
+	}(currentConfig.Load(), ClustersMap)
+
+	metricsDir, err := filepath.Abs("../../configs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled := false
+	cfg := config.Default()
+	cfg.Server.MetricsDir = metricsDir
+	cfg.Collectors = map[string]config.CollectorConfig{"vm": {Enabled: &disabled}}
+	currentConfig.Store(cfg)
+	cluster := &nutanix.Cluster{Name: "lab"}
+	cluster.SetCollectors([]prometheus.Collector{newScrapeCollector(cfg, cluster, nil)})
+	ClustersMap = map[string]*nutanix.Cluster{"lab": cluster}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		url     string
+	}{
+		{"cluster endpoint", createClusterMetricsHandler(cluster, nil), "/metrics/lab?collect[]=vm"},
+		{"cluster endpoint with an enabled collector", createClusterMetricsHandler(cluster, nil), "/metrics/lab?collect[]=host&collect[]=vm"},
+		{"combined endpoint", metricsHandler, "/metrics?collect[]=vm"},
+		{"unknown collector", metricsHandler, "/metrics?collect[]=disk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServedName(t *testing.T) {
+	taken := map[string]*nutanix.Cluster{
+		"prod":    {Name: "prod"},
+		"pc2-lab": {Name: "pc2-lab"},
+		"lab":     {Name: "lab"},
+	}
+	static := map[string]config.ClusterConfig{
+		"edge":      {URL: "https://edge:9440"},
+		"pc2-stage": {URL: "https://stage:9440"},
+		"dev":       {Collectors: map[string]config.CollectorConfig{}}, // Overrides only, not a static cluster
+	}
+	tests := []struct {
+		name      string
+		conflicts string
+		cluster   string
+		want      string
+		wantOK    bool
+	}{
+		{"free name", config.NameConflictPrefix, "dev", "dev", true},
+		{"conflict is prefixed", config.NameConflictPrefix, "prod", "pc2-prod", true},
+		{"conflict is skipped", config.NameConflictSkip, "prod", "", false},
+		{"prefixed name is taken", config.NameConflictPrefix, "lab", "", false},
+		{"static cluster replaces it", config.NameConflictPrefix, "edge", "", false},
+		{"static name of another cluster is free", config.NameConflictPrefix, "stage", "stage", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.NameConflicts = tt.conflicts
+			cfg.Clusters = static
+			name, ok := servedName(cfg, tt.cluster, "pc2", taken)
+			if name != tt.want || ok != tt.wantOK {
+				t.Errorf("servedName(%q) = %q, %v, want %q, %v", tt.cluster, name, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	// The prefixed name of a taken cluster can collide with a static cluster too
+	cfg := config.Default()
+	cfg.Clusters = static
+	if _, ok := servedName(cfg, "stage", "pc2", map[string]*nutanix.Cluster{"stage": {Name: "stage"}}); ok {
+		t.Error("servedName renamed a cluster to the name of a static cluster")
+	}
+}
+
+// label returns a label pair
+func label(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)}
+}
+
+func TestLabelGatherer(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []*dto.LabelPair
+		want   []*dto.LabelPair
+	}{
+		{
+			name:   "adds a missing label in order",
+			labels: []*dto.LabelPair{label("cluster_name", "prod"), label("vm_name", "db01")},
+			want:   []*dto.LabelPair{label("cluster_name", "prod"), label("pc_name", "pc-eu"), label("vm_name", "db01")},
+		},
+		{
+			name:   "keeps an existing label",
+			labels: []*dto.LabelPair{label("cluster_name", "pc-eu"), label("pc_name", "pc-us")},
+			want:   []*dto.LabelPair{label("cluster_name", "pc-eu"), label("pc_name", "pc-us")},
+		},
+		{
+			name: "no labels",
+			want: []*dto.LabelPair{label("pc_name", "pc-eu")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			family := &dto.MetricFamily{
+				Name:   proto.String("nutanix_up"),
+				Type:   dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{Label: tt.labels, Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+			}
+			g := labelGatherer{
+				Gatherer: prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return []*dto.MetricFamily{family}, nil }),
+				labels:   map[string]string{"pc_name": "pc-eu"},
+			}
+			families, err := g.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := families[0].Metric[0].Label; !proto.Equal(&dto.Metric{Label: got}, &dto.Metric{Label: tt.want}) {
+				t.Errorf("labels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
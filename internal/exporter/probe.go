@@ -0,0 +1,138 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ingka-group/nutanix-exporter/internal/config"
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeHandler handles the /probe endpoint in the style of the blackbox and snmp exporters
+// Runs the collectors of ?module=<name> for the cluster at ?target=<url>. Served clusters reuse their
+// client and credentials; other targets get a temporary client if their host is in probe.allowed_targets,
+// so credentials are never sent to a host chosen by the caller.
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	target := query.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil || targetURL.Host == "" {
+		http.Error(w, fmt.Sprintf("invalid target %q, expected a URL such as https://host:9440", target), http.StatusBadRequest)
+		return
+	}
+
+	moduleName := query.Get("module")
+	if moduleName == "" {
+		moduleName = DefaultSection
+	}
+	module, ok := Config().Modules[moduleName]
+	if !ok && moduleName != DefaultSection {
+		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+	if err := validateCollectors(module); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var collectors []prometheus.Collector
+	cluster := clusterForTarget(targetURL)
+	if cluster != nil {
+		collectors = cluster.Collectors()
+		if _, disabled := splitCollectors(collectorNames(collectors), module.Collectors); len(disabled) > 0 {
+			http.Error(w, fmt.Sprintf("collectors %v of module %s are disabled for cluster %s", disabled, moduleName, cluster.Name), http.StatusBadRequest)
+			return
+		}
+		cluster.RefreshCredentialsIfNeeded(VaultClient)
+	} else {
+		host := strings.ToLower(targetURL.Hostname())
+		if !Config().Probe.Allows(host) {
+			http.Error(w, fmt.Sprintf("target %q is not a served cluster and its host is not in probe.allowed_targets", target), http.StatusForbidden)
+			return
+		}
+		if _, disabled := splitCollectors(Config().EnabledCollectors(host), module.Collectors); len(disabled) > 0 {
+			http.Error(w, fmt.Sprintf("collectors %v of module %s are disabled for %s", disabled, moduleName, host), http.StatusBadRequest)
+			return
+		}
+
+		secret := module.Credentials
+		if secret == "" {
+			secret = Config().SecretFor(host)
+		}
+		cluster = nutanix.NewCluster(host, target, secret, VaultClient, false, clientOptions(host))
+		if cluster == nil {
+			http.Error(w, fmt.Sprintf("failed to create client for %s", host), http.StatusInternalServerError)
+			return
+		}
+		defer cluster.API.CloseIdleConnections()
+		collectors = []prometheus.Collector{NewScrapeCollector(cluster, module.Collectors)}
+	}
+
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	gatherer, err := clusterGatherer(ctx, cluster, collectors, module.Collectors)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// clusterForTarget returns the served cluster whose URL has the same host and port as the target, or nil
+// A missing port means the Prism port 9440
+func clusterForTarget(target *url.URL) *nutanix.Cluster {
+	for _, cluster := range ClustersMap {
+		clusterURL, err := url.Parse(cluster.URL)
+		if err == nil && hostPort(clusterURL) == hostPort(target) {
+			return cluster
+		}
+	}
+	return nil
+}
+
+// hostPort returns the lower case host and port of the URL, defaulting to the Prism port
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "9440"
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// validateCollectors returns an error if the module references an unknown collector
+func validateCollectors(module config.ModuleConfig) error {
+	for _, name := range module.Collectors {
+		if _, ok := collectorFactories[name]; !ok {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ingka-group/nutanix-exporter/internal/auth"
+	"github.com/ingka-group/nutanix-exporter/internal/config"
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+)
+
+// requestLog records the paths of the requests received by a stub server
+type requestLog struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (l *requestLog) add(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.paths = append(l.paths, path)
+}
+
+func (l *requestLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.paths...)
+}
+
+// newVaultStub returns a Vault server accepting any AppRole login and serving KV v2 credentials for every secret
+func newVaultStub(t *testing.T, reads *requestLog) *auth.VaultClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/auth/approle/login" {
+			w.Write([]byte(`{"data": {}, "auth": {"client_token": "token"}}`))
+			return
+		}
+		reads.add(r.URL.Path)
+		w.Write([]byte(`{"data": {"data": {"username": "admin", "secret": "secret"}, "metadata": {"version": 1}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := auth.NewVaultClient(auth.VaultConfig{
+		Address:       server.URL,
+		RoleID:        "role",
+		SecretID:      "secret",
+		Namespace:     "ns",
+		EngineName:    "kv",
+		EngineType:    auth.EngineKVv2,
+		PCTaskAccount: "pc",
+		PETaskAccount: "pe",
+	})
+	if err != nil {
+		t.Fatalf("NewVaultClient: %v", err)
+	}
+	return client
+}
+
+func TestProbeHandler(t *testing.T) {
+	metricsDir, err := filepath.Abs("../../configs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prismRequests requestLog
+	prism := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user == "admin" {
+			prismRequests.add(r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"entities": []}`))
+	}))
+	defer prism.Close()
+
+	disabled := false
+	tests := []struct {
+		name       string
+		target     string
+		module     string
+		wantStatus int
+		wantSecret string // Vault path read for the target, empty expects none
+	}{
+		{
+			name:       "allowed target",
+			target:     prism.URL,
+			module:     "edge",
+			wantStatus: http.StatusOK,
+			wantSecret: "/v1/kv/data/edge-sites/pe",
+		},
+		{
+			name:       "allowed target with the host secret",
+			target:     prism.URL,
+			wantStatus: http.StatusOK,
+			wantSecret: "/v1/kv/data/127.0.0.1/pe",
+		},
+		{
+			name:       "target outside the allow-list",
+			target:     "https://attacker.example.com:9440",
+			module:     "edge",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "allow-list is anchored",
+			target:     "https://127.0.0.1.attacker.example.com:9440",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "module with a disabled collector",
+			target:     prism.URL,
+			module:     "vms",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid target",
+			target:     "cluster:9440",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown module",
+			target:     prism.URL,
+			module:     "missing",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(cfg *config.Config, clusters map[string]*nutanix.Cluster, vault *auth.VaultClient) {
+				currentConfig.Store(cfg)
+				ClustersMap = clusters
+				VaultClient = vault
+			}(currentConfig.Load(), ClustersMap, VaultClient)
+
+			var vaultReads requestLog
+			VaultClient = newVaultStub(t, &vaultReads)
+			ClustersMap = map[string]*nutanix.Cluster{}
+			cfg := config.Default()
+			cfg.Server.MetricsDir = metricsDir
+			cfg.Modules = map[string]config.ModuleConfig{
+				"edge": {Collectors: []string{"cluster"}, Credentials: "edge-sites"},
+				"vms":  {Collectors: []string{"vm"}},
+			}
+			cfg.Collectors = map[string]config.CollectorConfig{"vm": {Enabled: &disabled}}
+			cfg.Probe.AllowedTargets = []string{`127\.0\.0\.1`}
+			currentConfig.Store(cfg)
+			prismRequests = requestLog{}
+
+			query := "target=" + tt.target
+			if tt.module != "" {
+				query += "&module=" + tt.module
+			}
+			rec := httptest.NewRecorder()
+			probeHandler(rec, httptest.NewRequest(http.MethodGet, "/probe?"+query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			reads := vaultReads.get()
+			if tt.wantSecret == "" {
+				if len(reads) > 0 {
+					t.Errorf("read Vault secrets %v for a rejected target", reads)
+				}
+				if got := prismRequests.get(); len(got) > 0 {
+					t.Errorf("sent credentials to Prism for a rejected target: %v", got)
+				}
+				return
+			}
+			if len(reads) != 1 || reads[0] != tt.wantSecret {
+				t.Errorf("Vault reads = %v, want %s", reads, tt.wantSecret)
+			}
+			if len(prismRequests.get()) == 0 {
+				t.Error("the target was not scraped")
+			}
+			if !strings.Contains(rec.Body.String(), `nutanix_up{cluster_name="127.0.0.1"}`) {
+				t.Errorf("probe did not return the status of the target:\n%s", rec.Body.String())
+			}
+		})
+	}
+}
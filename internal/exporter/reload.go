@@ -0,0 +1,329 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/config"
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+	"github.com/ingka-group/nutanix-exporter/internal/prom"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Loader reads the configuration with its overrides applied and validates it
+type Loader func() (*config.Config, error)
+
+var (
+	reloadSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nutanix_exporter_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful",
+		},
+	)
+	reloadTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nutanix_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(reloadSuccess, reloadTimestamp)
+}
+
+// reloader rebuilds the collectors of every cluster from a freshly loaded configuration
+type reloader struct {
+	mu         sync.Mutex
+	configPath string
+	load       Loader
+	modTimes   map[string]time.Time // Modification times of the files seen by the last reload
+}
+
+var reload reloader
+
+// setLoader enables reloading once the clusters are set up
+func (r *reloader) setLoader(configPath string, load Loader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.configPath, r.load = configPath, load
+	r.modTimes = r.snapshot(Config())
+	reloadSuccess.Set(1)
+	reloadTimestamp.SetToCurrentTime()
+}
+
+// Reload reads the configuration and the metric configs again and swaps the collectors of every cluster
+// An invalid configuration is rejected and the current collectors are kept
+func Reload() error {
+	return reload.reload()
+}
+
+// reload implements Reload
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.load == nil {
+		return errors.New("exporter is not initialized yet")
+	}
+
+	cfg, err := r.apply()
+	// Watch the files of a rejected configuration too, so fixing one of them triggers the next reload
+	r.modTimes = r.snapshot(Config(), cfg)
+	if err != nil {
+		reloadSuccess.Set(0)
+		return err
+	}
+	reloadSuccess.Set(1)
+	reloadTimestamp.SetToCurrentTime()
+	return nil
+}
+
+// apply loads and validates the configuration, builds every collector and only then swaps them in
+// Returns the loaded configuration, also when it was rejected after loading
+func (r *reloader) apply() (*config.Config, error) {
+	loaded, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	current := Config()
+	cfg := reloadable(current, loaded)
+	if err := validateMetricConfigs(cfg); err != nil {
+		return loaded, err
+	}
+
+	collectors := make(map[*nutanix.Cluster]*prom.ScrapeCollector, len(ClustersMap))
+	for _, cluster := range ClustersMap {
+		scrape := newScrapeCollector(cfg, cluster, nil)
+		for _, old := range cluster.Collectors() {
+			if old, ok := old.(*prom.ScrapeCollector); ok {
+				scrape.KeepStatus(old)
+			}
+		}
+		collectors[cluster] = scrape
+	}
+
+	if sections := restartRequired(current, loaded); len(sections) > 0 {
+		slog.Warn("Changes to these sections are ignored until a restart", "sections", sections)
+	}
+
+	// Scrapes see either the old or the new collectors of every cluster, never a mix
+	swapMu.Lock()
+	currentConfig.Store(cfg)
+	for cluster, scrape := range collectors {
+		cluster.SetCollectors([]prometheus.Collector{scrape})
+	}
+	swapMu.Unlock()
+
+	slog.Info("Reloaded configuration", "clusters", len(collectors))
+	return loaded, nil
+}
+
+// swapMu is held for writing while a reload swaps the configuration and the collectors of every cluster
+var swapMu sync.RWMutex
+
+// currentCollectors returns the collectors of the clusters, all taken from the same reload
+func currentCollectors(clusters map[string]*nutanix.Cluster) map[*nutanix.Cluster][]prometheus.Collector {
+	swapMu.RLock()
+	defer swapMu.RUnlock()
+
+	collectors := make(map[*nutanix.Cluster][]prometheus.Collector, len(clusters))
+	for _, cluster := range clusters {
+		collectors[cluster] = cluster.Collectors()
+	}
+	return collectors
+}
+
+// reloadable returns the current configuration with the sections that take effect on reload
+// taken from cfg: the collector settings, the probe modules and the probe allow-list. Other sections are only read
+// at startup and keep their current values until a restart.
+func reloadable(current, cfg *config.Config) *config.Config {
+	merged := *current
+	merged.Collectors = cfg.Collectors
+	merged.Modules = cfg.Modules
+	merged.Probe = cfg.Probe
+	merged.Clusters = make(map[string]config.ClusterConfig, len(current.Clusters))
+	for name, cluster := range current.Clusters {
+		cluster.Collectors = nil
+		merged.Clusters[name] = cluster
+	}
+	for name, cluster := range cfg.Clusters {
+		settings := merged.Clusters[name]
+		settings.Collectors = cluster.Collectors
+		merged.Clusters[name] = settings
+	}
+	return &merged
+}
+
+// snapshot returns the modification times of the configuration file and every metric config the configurations reference
+func (r *reloader) snapshot(cfgs ...*config.Config) map[string]time.Time {
+	paths := []string{r.configPath}
+	for _, cfg := range cfgs {
+		if cfg != nil {
+			paths = append(paths, metricConfigPaths(cfg)...)
+		}
+	}
+	return modTimes(paths)
+}
+
+// modTimes returns the modification times of the files, the zero time for missing ones
+func modTimes(paths []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		} else {
+			modTimes[path] = time.Time{}
+		}
+	}
+	return modTimes
+}
+
+// changed reports whether any watched file was modified since the last reload
+func (r *reloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	paths := make([]string, 0, len(r.modTimes))
+	for path := range r.modTimes {
+		paths = append(paths, path)
+	}
+	return !reflect.DeepEqual(r.modTimes, modTimes(paths))
+}
+
+// watch polls the watched files every interval and reloads when one of them changes
+// Blocks until the context is cancelled.
+func (r *reloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if r.changed() {
+			slog.Info("Configuration files changed, reloading")
+			if err := r.reload(); err != nil {
+				slog.Error("Failed to reload configuration, keeping the current one", "err", err)
+			}
+		}
+	}
+}
+
+// reloadHandler handles the /-/reload endpoint
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := Reload(); err != nil {
+		slog.Error("Failed to reload configuration, keeping the current one", "err", err)
+		http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// metricConfigPaths returns the sorted metric config files referenced by the configuration
+func metricConfigPaths(cfg *config.Config) []string {
+	names := []string{""} // Settings of clusters without overrides
+	for name := range cfg.Clusters {
+		names = append(names, name)
+	}
+	for name := range ClustersMap {
+		names = append(names, name)
+	}
+
+	seen := make(map[string]bool)
+	var paths []string
+	for _, name := range names {
+		for _, settings := range cfg.CollectorsFor(name) {
+			if !seen[settings.ConfigPath] {
+				seen[settings.ConfigPath] = true
+				paths = append(paths, settings.ConfigPath)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// validateMetricConfigs returns an error for every metric config that cannot be loaded
+func validateMetricConfigs(cfg *config.Config) error {
+	var errs []error
+	for _, path := range metricConfigPaths(cfg) {
+		if _, err := prom.LoadMetricConfig(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// restartRequired returns the configuration sections that changed but are only read at startup
+func restartRequired(old, new *config.Config) []string {
+	// Entries only overriding collectors of discovered clusters take effect on reload
+	withoutCollectors := func(clusters map[string]config.ClusterConfig) map[string]config.ClusterConfig {
+		stripped := make(map[string]config.ClusterConfig, len(clusters))
+		for name, cluster := range clusters {
+			cluster.Collectors = nil
+			if !reflect.DeepEqual(cluster, config.ClusterConfig{}) {
+				stripped[name] = cluster
+			}
+		}
+		return stripped
+	}
+
+	var sections []string
+	for _, section := range []struct {
+		name     string
+		old, new any
+	}{
+		{"server", old.Server, new.Server},
+		{"prism_central", old.PrismCentral, new.PrismCentral},
+		{"prism_centrals", old.PrismCentrals, new.PrismCentrals},
+		{"name_conflicts", old.NameConflicts, new.NameConflicts},
+		{"cluster_filters", old.ClusterFilters, new.ClusterFilters},
+		{"sharding", old.Sharding, new.Sharding},
+		{"vault", old.Vault, new.Vault},
+		{"transport", old.Transport, new.Transport},
+		{"tls", old.TLS, new.TLS},
+		{"retry", old.Retry, new.Retry},
+		{"circuit_breaker", old.Breaker, new.Breaker},
+		{"rate_limit", old.RateLimit, new.RateLimit},
+		{"proxy", old.Proxy, new.Proxy},
+		{"dedup", old.Dedup, new.Dedup},
+		{"service_discovery", old.ServiceDiscovery, new.ServiceDiscovery},
+		{"clusters", withoutCollectors(old.Clusters), withoutCollectors(new.Clusters)},
+	} {
+		if !reflect.DeepEqual(section.old, section.new) {
+			sections = append(sections, section.name)
+		}
+	}
+	return sections
+}
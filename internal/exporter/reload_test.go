@@ -0,0 +1,242 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/config"
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+	"github.com/ingka-group/nutanix-exporter/internal/prom"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRestartRequired(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name   string
+		modify func(*config.Config)
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			modify: func(*config.Config) {},
+		},
+		{
+			name: "collectors and modules reload",
+			modify: func(c *config.Config) {
+				c.Collectors = map[string]config.CollectorConfig{"vm": {Enabled: &disabled}}
+				c.Modules = map[string]config.ModuleConfig{"vms": {Collectors: []string{"vm"}}}
+				c.Clusters = map[string]config.ClusterConfig{"lab": {Collectors: map[string]config.CollectorConfig{"host": {Timeout: time.Minute}}}}
+			},
+		},
+		{
+			name: "startup sections",
+			modify: func(c *config.Config) {
+				c.Server.ListenAddress = ":9500"
+				c.Sharding.Count = 2
+				c.Vault.Address = "https://vault.example.com"
+				c.RateLimit.RequestsPerSecond = 5
+			},
+			want: []string{"server", "sharding", "vault", "rate_limit"},
+		},
+		{
+			name: "cluster connection settings",
+			modify: func(c *config.Config) {
+				c.Clusters = map[string]config.ClusterConfig{"lab": {URL: "https://lab:9440"}}
+			},
+			want: []string{"clusters"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.modify(cfg)
+			if got := restartRequired(config.Default(), cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restartRequired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadable(t *testing.T) {
+	disabled := false
+	current := config.Default()
+	current.Sharding = config.ShardingConfig{Index: 0, Count: 2}
+	current.Clusters = map[string]config.ClusterConfig{
+		"lab": {URL: "https://lab:9440", Credentials: "lab-secret", Collectors: map[string]config.CollectorConfig{"vm": {Enabled: &disabled}}},
+	}
+
+	loaded := config.Default()
+	loaded.Sharding = config.ShardingConfig{Index: 1, Count: 3}
+	loaded.Collectors = map[string]config.CollectorConfig{"host": {Timeout: time.Minute}}
+	loaded.Clusters = map[string]config.ClusterConfig{
+		"lab":  {URL: "https://other:9440", Credentials: "other-secret"},
+		"prod": {URL: "https://prod:9440", Collectors: map[string]config.CollectorConfig{"vm": {Enabled: &disabled}}},
+	}
+
+	merged := reloadable(current, loaded)
+	if merged.Sharding != current.Sharding {
+		t.Errorf("sharding = %+v, want the current %+v", merged.Sharding, current.Sharding)
+	}
+	if !reflect.DeepEqual(merged.Collectors, loaded.Collectors) {
+		t.Errorf("collectors = %+v, want the loaded %+v", merged.Collectors, loaded.Collectors)
+	}
+	if lab := merged.Clusters["lab"]; lab.URL != "https://lab:9440" || lab.Credentials != "lab-secret" || lab.Collectors != nil {
+		t.Errorf("clusters.lab = %+v, want the current connection settings with the loaded collectors", lab)
+	}
+	if prod := merged.Clusters["prod"]; prod.URL != "" || !reflect.DeepEqual(prod.Collectors, loaded.Clusters["prod"].Collectors) {
+		t.Errorf("clusters.prod = %+v, want only the loaded collectors", prod)
+	}
+	if got := merged.StaticClusters(); !reflect.DeepEqual(got, []string{"lab"}) {
+		t.Errorf("static clusters = %v, want [lab]", got)
+	}
+	if current.Clusters["lab"].Collectors == nil {
+		t.Error("reloadable modified the current configuration")
+	}
+}
+
+// gaugeValue returns the current value of the gauge
+func gaugeValue(g prometheus.Gauge) float64 {
+	var m dto.Metric
+	g.Write(&m)
+	return m.GetGauge().GetValue()
+}
+
+// enabledCollectors returns the sorted collectors run for the cluster
+func enabledCollectors(cluster *nutanix.Cluster) []string {
+	var names []string
+	for _, collector := range cluster.Collectors() {
+		for name := range collector.(*prom.ScrapeCollector).Collectors {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestReload(t *testing.T) {
+	metricsDir, err := filepath.Abs("../../configs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(t.TempDir(), "broken.yaml")
+	if err := os.WriteFile(broken, []byte("- name: bad name\n  help: Not a metric name.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	disabled := false
+
+	tests := []struct {
+		name        string
+		load        func(*config.Config) error
+		wantErr     bool
+		wantEnabled []string
+		wantWatched string // Path watched after the reload
+	}{
+		{
+			name: "collector settings",
+			load: func(c *config.Config) error {
+				c.Collectors = map[string]config.CollectorConfig{"vm": {Enabled: &disabled}}
+				return nil
+			},
+			wantEnabled: []string{"cluster", "host", "storage_container"},
+		},
+		{
+			name: "invalid metric config is rejected",
+			load: func(c *config.Config) error {
+				c.Collectors = map[string]config.CollectorConfig{"vm": {ConfigPath: broken}}
+				return nil
+			},
+			wantErr:     true,
+			wantEnabled: []string{"cluster", "host", "storage_container", "vm"},
+			wantWatched: broken,
+		},
+		{
+			name:        "loader error",
+			load:        func(*config.Config) error { return errors.New("invalid configuration") },
+			wantErr:     true,
+			wantEnabled: []string{"cluster", "host", "storage_container", "vm"},
+		},
+		{
+			name: "restart-only settings are kept",
+			load: func(c *config.Config) error {
+				c.Sharding = config.ShardingConfig{Index: 1, Count: 2}
+				c.Collectors = map[string]config.CollectorConfig{"host": {Enabled: &disabled}}
+				return nil
+			},
+			wantEnabled: []string{"cluster", "storage_container", "vm"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(cfg *config.Config, clusters map[string]*nutanix.Cluster) {
+				currentConfig.Store(cfg)
+				ClustersMap = clusters
+			}(currentConfig.Load(), ClustersMap)
+
+			initial := config.Default()
+			initial.Server.MetricsDir = metricsDir
+			currentConfig.Store(initial)
+			cluster := &nutanix.Cluster{Name: "lab"}
+			cluster.SetCollectors([]prometheus.Collector{newScrapeCollector(initial, cluster, nil)})
+			ClustersMap = map[string]*nutanix.Cluster{"lab": cluster}
+
+			r := &reloader{}
+			r.setLoader(filepath.Join(t.TempDir(), "exporter.yaml"), func() (*config.Config, error) {
+				cfg := config.Default()
+				cfg.Server.MetricsDir = metricsDir
+				if err := tt.load(cfg); err != nil {
+					return nil, err
+				}
+				return cfg, nil
+			})
+
+			err := r.reload()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reload = %v, want error %v", err, tt.wantErr)
+			}
+			if got, want := gaugeValue(reloadSuccess), map[bool]float64{false: 1, true: 0}[tt.wantErr]; got != want {
+				t.Errorf("last reload successful = %v, want %v", got, want)
+			}
+			if got := enabledCollectors(cluster); !reflect.DeepEqual(got, tt.wantEnabled) {
+				t.Errorf("collectors = %v, want %v", got, tt.wantEnabled)
+			}
+			if Config().Sharding != initial.Sharding {
+				t.Errorf("sharding = %+v, want it kept until a restart", Config().Sharding)
+			}
+			if tt.wantWatched != "" {
+				if _, ok := r.modTimes[tt.wantWatched]; !ok {
+					t.Errorf("%s is not watched after the rejected reload", tt.wantWatched)
+				}
+			}
+		})
+	}
+}
+
+func TestReloadNotInitialized(t *testing.T) {
+	var r reloader
+	if err := r.reload(); err == nil {
+		t.Error("reload before initialization = nil, want an error")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// targetGroup is a target group in the Prometheus http_sd and file_sd format
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// metaLabelPrefix is the prefix of the discovery labels, dropped by Prometheus after relabeling
+const metaLabelPrefix = "__meta_nutanix_"
+
+// targetGroups returns one target group per cluster, scraped from the exporter at the given address
+// The cluster attributes are published as __meta_nutanix_<name> labels, since the exporter already
+// puts cluster_name and pc_name on every series; only the configured labels of static clusters are target labels
+func targetGroups(address string) []targetGroup {
+	names := make([]string, 0, len(ClustersMap))
+	for name := range ClustersMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	groups := make([]targetGroup, 0, len(names))
+	for _, name := range names {
+		labels := map[string]string{
+			"__metrics_path__":               fmt.Sprintf("/metrics/%s", name),
+			metaLabelPrefix + "cluster_name": name,
+		}
+		static := Config().Clusters[name].URL != ""
+		for key, value := range ClustersMap[name].Labels {
+			if value == "" {
+				continue
+			}
+			labels[metaLabelPrefix+key] = value
+			if static {
+				labels[key] = value
+			}
+		}
+		groups = append(groups, targetGroup{Targets: []string{address}, Labels: labels})
+	}
+	return groups
+}
+
+// sdHandler handles the /sd endpoint, listing every cluster in the Prometheus http_sd format
+// Targets point at the address the request was sent to
+func sdHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(targetGroups(r.Host)); err != nil {
+		slog.Error("Error encoding service discovery response", "err", err)
+	}
+}
+
+// sdAddress returns the exporter address listed in the file_sd targets
+// Defaults to the host name and the port of the listen address
+func sdAddress() string {
+	if Config().ServiceDiscovery.Target != "" {
+		return Config().ServiceDiscovery.Target
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	_, port, err := net.SplitHostPort(Config().Server.ListenAddress)
+	if err != nil {
+		return hostname
+	}
+	return net.JoinHostPort(hostname, port)
+}
+
+// writeFileSD writes every cluster to the given file in the Prometheus file_sd format
+// The file is replaced atomically so Prometheus never reads a partial file
+func writeFileSD(path string) error {
+	data, err := json.MarshalIndent(targetGroups(sdAddress()), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ingka-group/nutanix-exporter/internal/config"
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+)
+
+func TestTargetGroups(t *testing.T) {
+	defer func(cfg *config.Config, clusters map[string]*nutanix.Cluster) {
+		currentConfig.Store(cfg)
+		ClustersMap = clusters
+	}(currentConfig.Load(), ClustersMap)
+
+	cfg := config.Default()
+	cfg.Clusters = map[string]config.ClusterConfig{
+		"edge": {URL: "https://edge:9440", Labels: map[string]string{"site": "store-042"}},
+	}
+	currentConfig.Store(cfg)
+	ClustersMap = map[string]*nutanix.Cluster{
+		"prod": {Name: "prod", Labels: map[string]string{"pc_name": "pc-eu", "cluster_uuid": "0005-aaaa", "hypervisor": "AHV", "aos_version": ""}},
+		"edge": {Name: "edge", Labels: cfg.Clusters["edge"].Labels},
+	}
+
+	want := []targetGroup{
+		{
+			Targets: []string{"exporter:9408"},
+			Labels: map[string]string{
+				"__metrics_path__":            "/metrics/edge",
+				"__meta_nutanix_cluster_name": "edge",
+				"__meta_nutanix_site":         "store-042",
+				"site":                        "store-042",
+			},
+		},
+		{
+			Targets: []string{"exporter:9408"},
+			Labels: map[string]string{
+				"__metrics_path__":            "/metrics/prod",
+				"__meta_nutanix_cluster_name": "prod",
+				"__meta_nutanix_pc_name":      "pc-eu",
+				"__meta_nutanix_cluster_uuid": "0005-aaaa",
+				"__meta_nutanix_hypervisor":   "AHV",
+			},
+		},
+	}
+	if got := targetGroups("exporter:9408"); !reflect.DeepEqual(got, want) {
+		t.Errorf("targetGroups = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"hash/fnv"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	shardInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nutanix_exporter_shard_info",
+			Help: "Shard served by this exporter replica, shard_count is 1 when sharding is disabled",
+		},
+		[]string{"shard_index", "shard_count"},
+	)
+	shardClusters = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nutanix_exporter_shard_clusters",
+			Help: "Number of clusters scraped by this exporter replica",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(shardInfo, shardClusters)
+}
+
+// shardOf returns the shard owning the cluster name out of count shards
+// Uses jump consistent hashing, so changing the shard count only moves the clusters of added or removed shards
+func shardOf(name string, count int) int {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	key := h.Sum64()
+
+	var b, j int64 = -1, 0
+	for j < int64(count) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// ownsCluster reports whether this replica scrapes the named cluster
+func ownsCluster(name string) bool {
+	sharding := Config().Sharding
+	return !sharding.Enabled() || shardOf(name, sharding.Count) == sharding.Index
+}
+
+// updateShardMetrics sets the shard metrics for the given number of owned clusters
+func updateShardMetrics(clusters int) {
+	index, count := Config().Sharding.Index, max(Config().Sharding.Count, 1)
+	shardInfo.Reset()
+	shardInfo.WithLabelValues(strconv.Itoa(index), strconv.Itoa(count)).Set(1)
+	shardClusters.Set(float64(clusters))
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ingka-group/nutanix-exporter/internal/config"
+)
+
+// clusterNames returns n cluster names shaped like the ones discovered from Prism Central
+func clusterNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("ntnx-site%03d-cl%d", i/4, i%4)
+	}
+	return names
+}
+
+func TestShardOfDistribution(t *testing.T) {
+	names := clusterNames(2000)
+	for _, count := range []int{1, 2, 3, 4, 7, 16} {
+		shards := make([]int, count)
+		for _, name := range names {
+			shard := shardOf(name, count)
+			if shard < 0 || shard >= count {
+				t.Fatalf("shardOf(%q, %d) = %d, out of range", name, count, shard)
+			}
+			shards[shard]++
+		}
+		// Every shard gets its fair share within 30%
+		fair := len(names) / count
+		for shard, n := range shards {
+			if n < fair*7/10 || n > fair*13/10 {
+				t.Errorf("%d shards: shard %d owns %d clusters, fair share is %d", count, shard, n, fair)
+			}
+		}
+	}
+}
+
+func TestShardOfStability(t *testing.T) {
+	names := clusterNames(2000)
+	tests := []struct {
+		from, to int
+	}{
+		{1, 2},
+		{4, 5},
+		{5, 4},
+		{10, 11},
+	}
+	for _, tt := range tests {
+		moved := 0
+		for _, name := range names {
+			before, after := shardOf(name, tt.from), shardOf(name, tt.to)
+			if before == after {
+				continue
+			}
+			moved++
+			// Growing only moves clusters to the new shard, shrinking only moves those of the removed shard
+			if tt.to > tt.from && after != tt.to-1 {
+				t.Errorf("%s moved from shard %d to %d when growing from %d to %d shards", name, before, after, tt.from, tt.to)
+			}
+			if tt.to < tt.from && before != tt.from-1 {
+				t.Errorf("%s moved from shard %d to %d when shrinking from %d to %d shards", name, before, after, tt.from, tt.to)
+			}
+		}
+		// About 1/max(from, to) of the clusters move
+		want := len(names) / max(tt.from, tt.to)
+		if moved < want*7/10 || moved > want*13/10 {
+			t.Errorf("%d to %d shards moved %d clusters, want about %d", tt.from, tt.to, moved, want)
+		}
+	}
+}
+
+func TestOwnsCluster(t *testing.T) {
+	defer currentConfig.Store(currentConfig.Load())
+
+	names := clusterNames(100)
+	tests := []struct {
+		name     string
+		sharding config.ShardingConfig
+	}{
+		{"disabled", config.ShardingConfig{}},
+		{"single shard", config.ShardingConfig{Count: 1}},
+		{"three shards", config.ShardingConfig{Count: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every cluster is owned by exactly one replica
+			owners := make(map[string]int)
+			for index := 0; index < max(tt.sharding.Count, 1); index++ {
+				cfg := config.Default()
+				cfg.Sharding = config.ShardingConfig{Index: index, Count: tt.sharding.Count}
+				currentConfig.Store(cfg)
+				for _, name := range names {
+					if ownsCluster(name) {
+						owners[name]++
+					}
+				}
+			}
+			for _, name := range names {
+				if owners[name] != 1 {
+					t.Errorf("%s is owned by %d replicas, want 1", name, owners[name])
+				}
+			}
+		})
+	}
+}
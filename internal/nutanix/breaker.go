@@ -0,0 +1,142 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting Prism while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerConfig holds the circuit breaker settings for a Prism instance
+type BreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"` // Consecutive failures before opening, 0 disables the breaker
+	OpenDuration     time.Duration `yaml:"open_duration"`     // Time to wait before probing an open breaker
+}
+
+// DefaultBreakerConfig returns the circuit breaker settings used when none are configured
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: 5,
+		OpenDuration:     30 * time.Second,
+	}
+}
+
+// BreakerState is the state of a circuit breaker, exported as a metric value
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Requests are sent
+	BreakerOpen                         // Requests fail fast
+	BreakerHalfOpen                     // A single probe request is sent
+)
+
+// breaker is a circuit breaker that stops sending requests to an unreachable Prism instance
+type breaker struct {
+	config   BreakerConfig
+	metrics  *clientMetrics
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// newBreaker returns a closed circuit breaker
+func newBreaker(config BreakerConfig, metrics *clientMetrics) *breaker {
+	b := &breaker{config: config, metrics: metrics}
+	b.setState(BreakerClosed)
+	return b
+}
+
+// setState moves the breaker to the given state and updates the metrics
+// Callers must hold b.mu, except during construction
+func (b *breaker) setState(state BreakerState) {
+	b.state = state
+	b.metrics.breakerState.Set(float64(state))
+	if state == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+}
+
+// allow reports whether a request may be sent, moving an expired open breaker to half-open
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.config.OpenDuration {
+			return false
+		}
+		b.setState(BreakerHalfOpen) // This request is the probe
+		return true
+	case BreakerHalfOpen:
+		return false // A probe is already in flight
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of a request
+func (b *breaker) record(success bool, host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		if b.state != BreakerClosed {
+			slog.Info("Circuit breaker closed", "host", host)
+		}
+		b.failures = 0
+		b.setState(BreakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.config.FailureThreshold) {
+		slog.Warn("Circuit breaker opened", "host", host, "failures", b.failures)
+		b.setState(BreakerOpen)
+	}
+}
+
+// middleware fails fast while the breaker is open
+// Connection errors and 5xx responses count as failures, other responses mean Prism is reachable
+func (b *breaker) middleware(next Doer) Doer {
+	return func(req *http.Request) (*http.Response, error) {
+		if b.config.FailureThreshold <= 0 {
+			return next(req)
+		}
+		if !b.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		resp, err := next(req)
+		if req.Context().Err() != nil {
+			// The caller gave up, this says nothing about the health of Prism
+			b.mu.Lock()
+			if b.state == BreakerHalfOpen {
+				b.setState(BreakerOpen)
+			}
+			b.mu.Unlock()
+			return resp, err
+		}
+		b.record(err == nil && resp.StatusCode < 500, req.URL.Host)
+		return resp, err
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+	// Each step records an outcome, or waits for the open duration, then checks the state
+	type step struct {
+		outcome string // "ok", "fail" or "wait"
+		state   BreakerState
+		allow   bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after the threshold",
+			steps: []step{
+				{"fail", BreakerClosed, true},
+				{"fail", BreakerClosed, true},
+				{"fail", BreakerOpen, false},
+			},
+		},
+		{
+			name: "success resets the failures",
+			steps: []step{
+				{"fail", BreakerClosed, true},
+				{"fail", BreakerClosed, true},
+				{"ok", BreakerClosed, true},
+				{"fail", BreakerClosed, true},
+				{"fail", BreakerClosed, true},
+			},
+		},
+		{
+			name: "successful probe closes",
+			steps: []step{
+				{"fail", BreakerClosed, true},
+				{"fail", BreakerClosed, true},
+				{"fail", BreakerOpen, false},
+				{"wait", BreakerHalfOpen, false},
+				{"ok", BreakerClosed, true},
+			},
+		},
+		{
+			name: "failed probe opens again",
+			steps: []step{
+				{"fail", BreakerClosed, true},
+				{"fail", BreakerClosed, true},
+				{"fail", BreakerOpen, false},
+				{"wait", BreakerHalfOpen, false},
+				{"fail", BreakerOpen, false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(BreakerConfig{FailureThreshold: 3, OpenDuration: time.Minute}, newClientMetrics())
+			for i, s := range tt.steps {
+				switch s.outcome {
+				case "ok":
+					b.record(true, "prism")
+				case "fail":
+					b.record(false, "prism")
+				case "wait":
+					b.openedAt = b.openedAt.Add(-time.Minute)
+					if !b.allow() {
+						t.Fatalf("step %d: expired open breaker did not let the probe through", i)
+					}
+				}
+				if b.state != s.state {
+					t.Fatalf("step %d: state = %d, want %d", i, b.state, s.state)
+				}
+				if s.state != BreakerHalfOpen {
+					if got := b.allow(); got != s.allow {
+						t.Fatalf("step %d: allow = %v, want %v", i, got, s.allow)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerMiddleware(t *testing.T) {
+	refused := func(*http.Request) (*http.Response, error) { return nil, errors.New("connection refused") }
+	tests := []struct {
+		name      string
+		threshold int
+		next      Doer
+		requests  int
+		wantCalls int
+	}{
+		{"disabled", 0, refused, 5, 5},
+		{"fails fast once open", 2, refused, 5, 2},
+		{"client errors keep it closed", 2, func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusUnauthorized}, nil
+		}, 5, 5},
+		{"server errors open it", 2, func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+		}, 5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(BreakerConfig{FailureThreshold: tt.threshold, OpenDuration: time.Minute}, newClientMetrics())
+			calls := 0
+			do := b.middleware(func(req *http.Request) (*http.Response, error) {
+				calls++
+				return tt.next(req)
+			})
+			for i := 0; i < tt.requests; i++ {
+				_, err := do(httptest.NewRequest(http.MethodGet, "https://prism:9440/api", nil))
+				if i >= tt.wantCalls && !errors.Is(err, ErrCircuitOpen) {
+					t.Errorf("request %d: err = %v, want ErrCircuitOpen", i, err)
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBreakerIgnoresCancelledRequests(t *testing.T) {
+	b := newBreaker(BreakerConfig{FailureThreshold: 1, OpenDuration: time.Minute}, newClientMetrics())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	do := b.middleware(func(req *http.Request) (*http.Response, error) { return nil, req.Context().Err() })
+
+	do(httptest.NewRequest(http.MethodGet, "https://prism:9440/api", nil).WithContext(ctx))
+	if b.state != BreakerClosed {
+		t.Errorf("state after a cancelled request = %d, want closed", b.state)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/ingka-group/nutanix-exporter/internal/auth"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PathBuilder returns the full request URL for an action of a Prism API family
+type PathBuilder func(baseURL, action string) string
+
+// PrismGatewayPaths builds Prism Element v1/v2 URLs under /PrismGateway/services/rest/ with a trailing slash
+func PrismGatewayPaths(baseURL, action string) string {
+	return fmt.Sprintf("%s/PrismGateway/services/rest/%s/", strings.Trim(baseURL, "/"), strings.Trim(action, "/"))
+}
+
+// APIPaths builds URLs for the /api/ families (PC v3, PC v4 and PE v4), where the action holds the full path
+func APIPaths(baseURL, action string) string {
+	return fmt.Sprintf("%s/%s", strings.Trim(baseURL, "/"), strings.Trim(action, "/"))
+}
+
+// Client is the Prism API client shared by Prism Central and Prism Element.
+// API families differ only in their PathBuilder; authentication and other
+// cross-cutting behaviour is implemented once as Middleware.
+type Client struct {
+	URL     string
+	Paths   PathBuilder
+	session *session
+	client  *http.Client // Long-lived client reusing pooled connections
+	metrics *clientMetrics
+	do      Doer // Middleware chain ending in client.Do
+}
+
+// NewClient returns a new Prism API client using the given path builder
+// Extra middleware wraps the built-in chain, outermost first
+func NewClient(url string, paths PathBuilder, creds auth.Credentials, opts ClientOptions, middleware ...Middleware) (*Client, error) {
+	session := newSession(creds)
+	client, err := newHTTPClient(opts, session)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		URL:     url,
+		Paths:   paths,
+		session: session,
+		client:  client,
+		metrics: newClientMetrics(),
+	}
+
+	chain := append([]Middleware{}, middleware...)
+	chain = append(chain,
+		c.metrics.requestMiddleware,                      // Duration and outcome of each call, including retries
+		newBreaker(opts.Breaker, c.metrics).middleware,   // Fail fast while Prism is unreachable
+		opts.Retry.middleware,                            // Retry transient failures
+		c.metrics.traceMiddleware,                        // Connection reuse
+		c.session.middleware,                             // Authentication
+		newLimiter(opts.RateLimit, c.metrics).middleware, // Limit every request sent to Prism
+	)
+	c.do = Chain(client.Do, chain...)
+	return c, nil
+}
+
+// NewPEClient returns a new Prism Element v2 client object
+func NewPEClient(url string, creds auth.Credentials, opts ClientOptions) (*Client, error) {
+	return NewClient(url, PrismGatewayPaths, creds, opts)
+}
+
+// NewPCClient returns a new Prism Central client object
+func NewPCClient(url string, creds auth.Credentials, opts ClientOptions) (*Client, error) {
+	return NewClient(url, APIPaths, creds, opts)
+}
+
+// SetCredentials replaces the credentials used by the client and drops its session
+func (c *Client) SetCredentials(creds auth.Credentials) {
+	c.session.setCredentials(creds)
+}
+
+// CreateRequest takes context, request type, action and request parameters
+// Returns a new http request; authentication is added by the middleware when it is sent
+func (c *Client) CreateRequest(ctx context.Context, reqType, action string, p RequestParams) (*http.Request, error) {
+	fullURL := c.Paths(c.URL, action)
+
+	slog.Debug("Sending request", "url", fullURL)
+
+	var req *http.Request
+	var err error
+
+	// Check if the payload is not nil and marshal it to JSON if needed
+	if p.Payload != nil {
+		jsonPayload, err := json.Marshal(p.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		req, err = http.NewRequestWithContext(ctx, reqType, fullURL, strings.NewReader(string(jsonPayload)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		// Use the old method with body as string if no payload is provided
+		req, err = http.NewRequestWithContext(ctx, reqType, fullURL, strings.NewReader(p.Body))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	return req, nil
+}
+
+// MakeRequestWithParams takes context, request type, action and request parameters
+// Returns a new http response
+func (c *Client) MakeRequestWithParams(ctx context.Context, reqType, action string, p RequestParams) (*http.Response, error) {
+	req, err := c.CreateRequest(ctx, reqType, action, p)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	return resp, nil
+}
+
+// MakeRequest takes context, request type, and action
+// Returns a new http response
+// Calls MakeRequestWithParams with empty RequestParams
+func (c *Client) MakeRequest(ctx context.Context, reqType, action string) (*http.Response, error) {
+	return c.MakeRequestWithParams(ctx, reqType, action, RequestParams{})
+}
+
+// CloseIdleConnections closes the pooled connections of a client that is no longer used
+func (c *Client) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+// Describe method required by prometheus.Collector interface
+func (c *Client) Describe(ch chan<- *prometheus.Desc) {
+	c.metrics.Describe(ch)
+}
+
+// Collect method required by prometheus.Collector interface
+func (c *Client) Collect(ch chan<- prometheus.Metric) {
+	c.metrics.Collect(ch)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/auth"
+)
+
+// credsVersion returns the current Vault secret version of the cluster credentials
+func (c *Cluster) credsVersion(vaultClient *auth.VaultClient) (int64, error) {
+	if c.IsPC {
+		return vaultClient.GetPCCredsVersion(c.Secret)
+	}
+	return vaultClient.GetPECredsVersion(c.Secret)
+}
+
+// WatchCredentials polls the KV V2 metadata of the cluster secret every interval
+// and swaps the credentials as soon as a new version is published.
+// Blocks until the context is cancelled.
+func (c *Cluster) WatchCredentials(ctx context.Context, vaultClient *auth.VaultClient, interval time.Duration) {
+	current, err := c.credsVersion(vaultClient)
+	if err != nil {
+		slog.Warn("Failed to read credentials version", "cluster", c.Name, "err", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		version, err := c.credsVersion(vaultClient)
+		if err != nil {
+			slog.Warn("Failed to read credentials version", "cluster", c.Name, "err", err)
+			continue
+		}
+		if version == current {
+			continue
+		}
+
+		c.Mutex.Lock()
+		if err := c.refreshCredentials(vaultClient); err != nil {
+			slog.Error("Failed to rotate credentials", "cluster", c.Name, "err", err)
+			c.Mutex.Unlock()
+			continue
+		}
+		c.RefreshNeeded = false
+		c.Mutex.Unlock()
+
+		slog.Info("Credentials rotated", "cluster", c.Name, "from_version", current, "to_version", version)
+		current = version
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clientMetrics holds the self-metrics recorded by a Prism API client
+type clientMetrics struct {
+	requestDuration *prometheus.HistogramVec
+	responses       *prometheus.CounterVec
+	errors          *prometheus.CounterVec
+	connections     *prometheus.CounterVec
+	breakerState    prometheus.Gauge
+	rateLimitWait   prometheus.Histogram
+}
+
+// newClientMetrics returns the self-metrics for a single Prism API client
+func newClientMetrics() *clientMetrics {
+	return &clientMetrics{
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "nutanix",
+				Subsystem: "api",
+				Name:      "request_duration_seconds",
+				Help:      "Duration of Prism API requests, including retries.",
+				Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+			},
+			[]string{"endpoint", "method"},
+		),
+		responses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nutanix",
+				Subsystem: "api",
+				Name:      "responses_total",
+				Help:      "Prism API responses by status code.",
+			},
+			[]string{"endpoint", "method", "code"},
+		),
+		errors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nutanix",
+				Subsystem: "api",
+				Name:      "request_errors_total",
+				Help:      "Prism API requests that failed without a response.",
+			},
+			[]string{"endpoint", "method"},
+		),
+		connections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nutanix",
+				Subsystem: "api",
+				Name:      "connections_total",
+				Help:      "Connections obtained for Prism API requests, by whether an idle connection was reused.",
+			},
+			[]string{"reused"},
+		),
+		breakerState: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "nutanix",
+				Subsystem: "api",
+				Name:      "circuit_breaker_state",
+				Help:      "State of the Prism API circuit breaker (0 closed, 1 open, 2 half-open).",
+			},
+		),
+		rateLimitWait: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: "nutanix",
+				Subsystem: "api",
+				Name:      "rate_limit_wait_seconds",
+				Help:      "Time Prism API requests spent waiting for the client-side rate limit and concurrency cap.",
+				Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			},
+		),
+	}
+}
+
+// requestMiddleware records the duration and outcome of every request sent through it
+func (m *clientMetrics) requestMiddleware(next Doer) Doer {
+	return func(req *http.Request) (*http.Response, error) {
+		endpoint, method := req.URL.Path, req.Method
+		start := time.Now()
+
+		resp, err := next(req)
+
+		m.requestDuration.WithLabelValues(endpoint, method).Observe(time.Since(start).Seconds())
+		if err != nil {
+			m.errors.WithLabelValues(endpoint, method).Inc()
+		} else {
+			m.responses.WithLabelValues(endpoint, method, strconv.Itoa(resp.StatusCode)).Inc()
+		}
+		return resp, err
+	}
+}
+
+// traceMiddleware records connection reuse for every request sent through it
+func (m *clientMetrics) traceMiddleware(next Doer) Doer {
+	return func(req *http.Request) (*http.Response, error) {
+		ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				m.connections.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+			},
+		})
+		return next(req.WithContext(ctx))
+	}
+}
+
+// Describe method required by prometheus.Collector interface
+func (m *clientMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requestDuration.Describe(ch)
+	m.responses.Describe(ch)
+	m.errors.Describe(ch)
+	m.connections.Describe(ch)
+	m.breakerState.Describe(ch)
+	m.rateLimitWait.Describe(ch)
+}
+
+// Collect method required by prometheus.Collector interface
+func (m *clientMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requestDuration.Collect(ch)
+	m.responses.Collect(ch)
+	m.errors.Collect(ch)
+	m.connections.Collect(ch)
+	m.breakerState.Collect(ch)
+	m.rateLimitWait.Collect(ch)
+}
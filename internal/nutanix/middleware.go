@@ -0,0 +1,51 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"net/http"
+)
+
+// Doer sends a single Prism API request
+type Doer func(req *http.Request) (*http.Response, error)
+
+// Middleware wraps a Doer with behaviour shared by every API family, e.g. authentication or retries
+type Middleware func(next Doer) Doer
+
+// Chain wraps the Doer with the given middleware, the first one being the outermost
+func Chain(do Doer, middleware ...Middleware) Doer {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		do = middleware[i](do)
+	}
+	return do
+}
+
+// rewind returns a copy of the request that can be sent again
+// The body is rebuilt and the authentication added by a previous attempt is dropped
+func rewind(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	clone.Header.Del("Authorization")
+	clone.Header.Del("Cookie")
+	clone.Header.Del(APIKeyHeader)
+	return clone, nil
+}
@@ -17,15 +17,11 @@ package nutanix
 
 import (
 	"context"
-	"crypto/tls"
-	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"strings"
 	"sync"
-	"time"
 
 	"github.com/ingka-group/nutanix-exporter/internal/auth"
 
@@ -33,10 +29,12 @@ import (
 )
 
 type NutanixClient interface {
-	RefreshCredentials(vaultClient *auth.VaultClient) error
+	prometheus.Collector // Self-metrics of the client
+	SetCredentials(creds auth.Credentials)
 	CreateRequest(ctx context.Context, reqType, action string, p RequestParams) (*http.Request, error)
 	MakeRequestWithParams(ctx context.Context, reqType, action string, p RequestParams) (*http.Response, error)
 	MakeRequest(ctx context.Context, reqType, action string) (*http.Response, error)
+	CloseIdleConnections()
 }
 
 // Cluster represents a Nutanix cluster (Prism Central OR Element)
@@ -45,29 +43,15 @@ type Cluster struct {
 	URL           string `yaml:"URL"`
 	API           NutanixClient
 	Registry      *prometheus.Registry
-	Collectors    []prometheus.Collector
+	collectors    []prometheus.Collector // Guarded by collectorsMu, replaced as a whole on reload
+	collectorsMu  sync.RWMutex
+	Labels        map[string]string // Discovery labels, e.g. pc_name or aos_version
+	Secret        string            // Vault secret the credentials are read from, defaults to Name
+	IsPC          bool
 	RefreshNeeded bool
 	Mutex         sync.Mutex
 }
 
-// PEClient represents the Prism Element API client
-type PEClient struct {
-	URL           string
-	Username      string
-	Password      string
-	SkipTLSVerify bool
-	Timeout       time.Duration
-}
-
-// PCClient represents the Prism Central API client
-type PCClient struct {
-	URL           string
-	Username      string
-	Password      string
-	SkipTLSVerify bool
-	Timeout       time.Duration
-}
-
 // RequestParams holds the components for a request (body, header, params)
 type RequestParams struct {
 	Body    string
@@ -77,53 +61,42 @@ type RequestParams struct {
 }
 
 // NewCluster returns a new Nutanix cluster object, fetching credentials and creating an API client.
-func NewCluster(name, url string, vaultClient *auth.VaultClient, isPC bool, skipTLSVerify bool, timeout time.Duration) *Cluster {
-	var api NutanixClient
-	var username, password string
+// Credentials are read from the given Vault secret, which defaults to the cluster name.
+func NewCluster(name, url, secret string, vaultClient *auth.VaultClient, isPC bool, opts ClientOptions) *Cluster {
+	if secret == "" {
+		secret = name
+	}
 
+	var creds auth.Credentials
+	var err error
+	kind, paths := "Prism Element", PrismGatewayPaths
 	if isPC {
-		username, password = vaultClient.GetPCCreds(name)
-		if username == "" || password == "" {
-			log.Printf("Failed to get credentials for Prism Central %s", name)
-			return nil
-		}
-		api = NewPCClient(url, username, password, skipTLSVerify, timeout)
+		kind, paths = "Prism Central", APIPaths
+		creds, err = vaultClient.ReadPCCreds(secret)
 	} else {
-		username, password = vaultClient.GetPECreds(name)
-		if username == "" || password == "" {
-			log.Printf("Failed to get credentials for Prism Element %s", name)
-			return nil
-		}
-		api = NewPEClient(url, username, password, skipTLSVerify, timeout)
+		creds, err = vaultClient.ReadPECreds(secret)
+	}
+	if err != nil || !creds.Valid() {
+		slog.Error("Failed to get credentials", "kind", kind, "cluster", name, "err", err)
+		return nil
 	}
 
+	api, err := NewClient(url, paths, creds, opts)
+	if err != nil {
+		slog.Error("Failed to create client", "kind", kind, "cluster", name, "err", err)
+		return nil
+	}
+
+	registry := prometheus.NewRegistry()
+	prometheus.WrapRegistererWith(prometheus.Labels{"cluster_name": name}, registry).MustRegister(api)
+
 	return &Cluster{
 		Name:     name,
 		URL:      url,
 		API:      api,
-		Registry: prometheus.NewRegistry(),
-	}
-}
-
-// NewPEClient returns a new Prism Element client object
-func NewPEClient(url, username, password string, skipTLSVerify bool, timeout time.Duration) *PEClient {
-	return &PEClient{
-		URL:           url,
-		Username:      username,
-		Password:      password,
-		SkipTLSVerify: skipTLSVerify,
-		Timeout:       timeout,
-	}
-}
-
-// NewPCClient returns a new Prism Central client object
-func NewPCClient(url, username, password string, skipTLSVerify bool, timeout time.Duration) *PCClient {
-	return &PCClient{
-		URL:           url,
-		Username:      username,
-		Password:      password,
-		SkipTLSVerify: skipTLSVerify,
-		Timeout:       timeout,
+		Registry: registry,
+		Secret:   secret,
+		IsPC:     isPC,
 	}
 }
 
@@ -133,161 +106,45 @@ func (c *Cluster) RefreshCredentialsIfNeeded(vaultClient *auth.VaultClient) {
 	defer c.Mutex.Unlock()
 
 	if c.RefreshNeeded {
-		if err := c.API.RefreshCredentials(vaultClient); err != nil {
-			log.Printf("Failed to refresh credentials for cluster %s: %v", c.Name, err)
+		if err := c.refreshCredentials(vaultClient); err != nil {
+			slog.Error("Failed to refresh credentials", "cluster", c.Name, "err", err)
 			return
 		}
 		c.RefreshNeeded = false // Reset the flag after refreshing
-		log.Printf("Credentials refreshed for cluster %s", c.Name)
-	}
-}
-
-// RefreshCredentials refreshes the credentials for the PEClient
-func (c *PEClient) RefreshCredentials(vaultClient *auth.VaultClient) error {
-	username, password := vaultClient.GetPECreds(c.URL)
-	if username == "" || password == "" {
-		return fmt.Errorf("failed to refresh credentials for PE client %s", c.URL)
-	}
-	c.Username = username
-	c.Password = password
-	return nil
-}
-
-// RefreshCredentials refreshes the credentials for the PCClient
-func (c *PCClient) RefreshCredentials(vaultClient *auth.VaultClient) error {
-	username, password := vaultClient.GetPCCreds(c.URL)
-	if username == "" || password == "" {
-		return fmt.Errorf("failed to refresh credentials for PC client %s", c.URL)
-	}
-	c.Username = username
-	c.Password = password
-	return nil
-}
-
-// CreateRequest takes context, request type, action, and request parameters
-// Returns a new HTTP request for PEClient
-func (c *PEClient) CreateRequest(ctx context.Context, reqType, action string, p RequestParams) (*http.Request, error) {
-	fullURL := fmt.Sprintf("%s/PrismGateway/services/rest/%s/", strings.Trim(c.URL, "/"), strings.Trim(action, "/"))
-
-	log.Printf("Sending request to %s", fullURL)
-
-	var req *http.Request
-	var err error
-
-	// Check if the payload is not nil and marshal it to JSON if needed
-	if p.Payload != nil {
-		jsonPayload, err := json.Marshal(p.Payload)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal payload: %w", err)
-		}
-		req, err = http.NewRequestWithContext(ctx, reqType, fullURL, strings.NewReader(string(jsonPayload)))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
-	} else {
-		// Use the old method with body as string if no payload is provided
-		req, err = http.NewRequestWithContext(ctx, reqType, fullURL, strings.NewReader(p.Body))
+		slog.Info("Credentials refreshed", "cluster", c.Name)
 	}
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.SetBasicAuth(c.Username, c.Password)
-	return req, nil
 }
 
-// CreateRequest takes context, request type, action and request parameters
-// Returns a new http request for PCClient
-func (c *PCClient) CreateRequest(ctx context.Context, reqType, action string, p RequestParams) (*http.Request, error) {
-	fullURL := fmt.Sprintf("%s/%s", strings.Trim(c.URL, "/"), strings.Trim(action, "/"))
-
-	log.Printf("Sending request to %s", fullURL)
-
-	var req *http.Request
+// refreshCredentials reads the cluster credentials from Vault and swaps them into the API client
+// Callers must hold c.Mutex
+func (c *Cluster) refreshCredentials(vaultClient *auth.VaultClient) error {
+	var creds auth.Credentials
 	var err error
-
-	// Check if the payload is not nil and marshal it to JSON if needed
-	if p.Payload != nil {
-		jsonPayload, err := json.Marshal(p.Payload)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal payload: %w", err)
-		}
-		req, err = http.NewRequestWithContext(ctx, reqType, fullURL, strings.NewReader(string(jsonPayload)))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
+	if c.IsPC {
+		creds, err = vaultClient.ReadPCCreds(c.Secret)
 	} else {
-		// Use the old method with body as string if no payload is provided
-		req, err = http.NewRequestWithContext(ctx, reqType, fullURL, strings.NewReader(p.Body))
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.SetBasicAuth(c.Username, c.Password)
-	return req, nil
-}
-
-// MakeRequestWithParams takes context, request type, action, and request parameters
-// Returns a new http response for PEClient
-func (c *PEClient) MakeRequestWithParams(ctx context.Context, reqType, action string, p RequestParams) (*http.Response, error) {
-	req, err := c.CreateRequest(ctx, reqType, action, p)
-	if err != nil {
-		return nil, err
-	}
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: c.SkipTLSVerify},
-		},
-		Timeout: c.Timeout,
+		creds, err = vaultClient.ReadPECreds(c.Secret)
 	}
-
-	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return err
 	}
-
-	return resp, nil
-}
-
-// MakeRequestWithParams takes context, request type, action and request parameters
-// Returns a new http response for PCClient
-func (c *PCClient) MakeRequestWithParams(ctx context.Context, reqType, action string, p RequestParams) (*http.Response, error) {
-	req, err := c.CreateRequest(ctx, reqType, action, p)
-	if err != nil {
-		return nil, err
-	}
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: c.SkipTLSVerify},
-		},
-		Timeout: c.Timeout,
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+	if !creds.Valid() {
+		return fmt.Errorf("empty credentials returned for cluster %s", c.Name)
 	}
-
-	return resp, nil
+	c.API.SetCredentials(creds)
+	return nil
 }
 
-// MakeRequest takes context, request type, and action
-// Returns a new http response
-// Calls MakeRequestWithParams with empty RequestParams for PEClient
-func (c *PEClient) MakeRequest(ctx context.Context, reqType, action string) (*http.Response, error) {
-	return c.MakeRequestWithParams(ctx, reqType, action, RequestParams{})
+// Collectors returns the collectors of the cluster
+func (c *Cluster) Collectors() []prometheus.Collector {
+	c.collectorsMu.RLock()
+	defer c.collectorsMu.RUnlock()
+	return c.collectors
 }
 
-// MakeRequest takes context, request type, and action
-// Returns a new http response
-// Calls MakeRequestWithParams with empty RequestParams for PCClient
-func (c *PCClient) MakeRequest(ctx context.Context, reqType, action string) (*http.Response, error) {
-	return c.MakeRequestWithParams(ctx, reqType, action, RequestParams{})
+// SetCollectors replaces the collectors of the cluster, scrapes in progress keep the previous ones
+func (c *Cluster) SetCollectors(collectors []prometheus.Collector) {
+	c.collectorsMu.Lock()
+	defer c.collectorsMu.Unlock()
+	c.collectors = collectors
 }
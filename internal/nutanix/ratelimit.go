@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig holds the client-side limits applied to requests sent to a Prism instance
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"` // Token bucket refill rate, 0 disables rate limiting
+	Burst             int     `yaml:"burst"`               // Token bucket size, defaults to 1
+	MaxInFlight       int     `yaml:"max_in_flight"`       // Concurrent requests, 0 means no limit
+}
+
+// limiter enforces a RateLimitConfig and records the time requests spend waiting
+type limiter struct {
+	bucket   *rate.Limiter
+	inFlight chan struct{}
+	metrics  *clientMetrics
+}
+
+// newLimiter returns a limiter for the given settings
+func newLimiter(config RateLimitConfig, metrics *clientMetrics) *limiter {
+	l := &limiter{metrics: metrics}
+	if config.RequestsPerSecond > 0 {
+		l.bucket = rate.NewLimiter(rate.Limit(config.RequestsPerSecond), max(config.Burst, 1))
+	}
+	if config.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, config.MaxInFlight)
+	}
+	return l
+}
+
+// releaseOnClose releases the in-flight slot once the response body has been consumed
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+// Close closes the body and releases the in-flight slot
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// middleware waits for a rate limit token and an in-flight slot before sending each request
+func (l *limiter) middleware(next Doer) Doer {
+	return func(req *http.Request) (*http.Response, error) {
+		if l.bucket == nil && l.inFlight == nil {
+			return next(req)
+		}
+
+		ctx := req.Context()
+		start := time.Now()
+
+		if l.bucket != nil {
+			if err := l.bucket.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		release := func() {}
+		if l.inFlight != nil {
+			select {
+			case l.inFlight <- struct{}{}:
+				release = func() { <-l.inFlight }
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		l.metrics.rateLimitWait.Observe(time.Since(start).Seconds())
+
+		resp, err := next(req)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+		return resp, nil
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    RateLimitConfig
+		closeBody bool
+		requests  int
+		wantSent  int // Requests sent before the context expires
+	}{
+		{"disabled", RateLimitConfig{}, false, 10, 10},
+		{"burst", RateLimitConfig{RequestsPerSecond: 0.1, Burst: 3}, true, 10, 3},
+		{"burst defaults to one", RateLimitConfig{RequestsPerSecond: 0.1}, true, 10, 1},
+		{"in flight until the body is closed", RateLimitConfig{MaxInFlight: 2}, false, 10, 2},
+		{"closed bodies release their slot", RateLimitConfig{MaxInFlight: 2}, true, 10, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.config, newClientMetrics())
+			do := l.middleware(func(*http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			sent := 0
+			for i := 0; i < tt.requests; i++ {
+				resp, err := do(httptest.NewRequest(http.MethodGet, "https://prism:9440/api", nil).WithContext(ctx))
+				if err != nil {
+					continue
+				}
+				sent++
+				if tt.closeBody {
+					resp.Body.Close()
+				}
+			}
+			if sent != tt.wantSent {
+				t.Errorf("sent = %d, want %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
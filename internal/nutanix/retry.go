@@ -0,0 +1,138 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryConfig holds the retry policy for idempotent Prism API calls
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"` // Total attempts including the first, 1 disables retries
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// DefaultRetryConfig returns the retry policy used when none is configured
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+	}
+}
+
+// retryable reports whether the request can safely be sent again
+// GETs are idempotent and the v3 list POSTs only read data
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		return strings.HasSuffix(strings.TrimSuffix(req.URL.Path, "/"), "/list")
+	}
+	return false
+}
+
+// transient reports whether the response status is worth retrying
+func transient(status int) bool {
+	return status == http.StatusTooManyRequests || (status >= 500 && status != http.StatusNotImplemented)
+}
+
+// retryAfter returns the delay requested by the Retry-After header, or 0 if there is none
+func retryAfter(resp *http.Response) time.Duration {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// backoff returns the delay before the given retry using exponential backoff with full jitter
+func (r RetryConfig) backoff(retry int) time.Duration {
+	delay := r.InitialBackoff << retry
+	if delay <= 0 || delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return rand.N(delay)
+}
+
+// middleware retries transient failures of idempotent requests
+// Gives up early when the next attempt would not fit in the request context deadline
+func (r RetryConfig) middleware(next Doer) Doer {
+	return func(req *http.Request) (*http.Response, error) {
+		if r.MaxAttempts <= 1 || !retryable(req) {
+			return next(req)
+		}
+
+		ctx := req.Context()
+		attempt := req
+		for retry := 0; ; retry++ {
+			resp, err := next(attempt)
+			if ctx.Err() != nil || retry+1 >= r.MaxAttempts {
+				return resp, err
+			}
+			if err == nil && !transient(resp.StatusCode) {
+				return resp, nil
+			}
+
+			delay := r.backoff(retry)
+			if err == nil {
+				if after := retryAfter(resp); after > delay {
+					delay = after
+				}
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+				return resp, err
+			}
+
+			if err != nil {
+				slog.Warn("Retrying request", "method", req.Method, "url", req.URL, "delay", delay, "err", err)
+			} else {
+				slog.Warn("Retrying request", "method", req.Method, "url", req.URL, "delay", delay, "status", resp.Status)
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+
+			if attempt, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{http.MethodGet, "/PrismGateway/services/rest/v2.0/vms", true},
+		{http.MethodHead, "/", true},
+		{http.MethodPost, "/api/nutanix/v3/clusters/list", true},
+		{http.MethodPost, "/api/nutanix/v3/clusters/list/", true},
+		{http.MethodPost, "/api/nutanix/v3/vms", false},
+		{http.MethodPut, "/api/nutanix/v3/vms/1", false},
+		{http.MethodDelete, "/api/nutanix/v3/vms/1", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "https://prism:9440"+tt.path, nil)
+		if got := retryable(req); got != tt.want {
+			t.Errorf("retryable(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusNotFound, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusNotImplemented, false},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		if got := transient(tt.status); got != tt.want {
+			t.Errorf("transient(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"", 0, 0},
+		{"3", 3 * time.Second, 3 * time.Second},
+		{"soon", 0, 0},
+		{time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 8 * time.Second, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		if got := retryAfter(resp); got < tt.min || got > tt.max {
+			t.Errorf("retryAfter(%q) = %v, want between %v and %v", tt.header, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	config := RetryConfig{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{62, time.Second}, // The shift overflows
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := config.backoff(tt.retry); got < 0 || got >= tt.max {
+				t.Fatalf("backoff(%d) = %v, want in [0, %v)", tt.retry, got, tt.max)
+			}
+		}
+	}
+
+	if got := (RetryConfig{}).backoff(0); got != 0 {
+		t.Errorf("backoff without delays = %v, want 0", got)
+	}
+}
+
+// sequence returns a Doer replying with the given statuses in order, a zero status returns an error
+func sequence(statuses ...int) (Doer, *int) {
+	calls := 0
+	return func(req *http.Request) (*http.Response, error) {
+		status := statuses[min(calls, len(statuses)-1)]
+		calls++
+		if status == 0 {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}, &calls
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	config := RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	tests := []struct {
+		name      string
+		config    RetryConfig
+		method    string
+		statuses  []int
+		timeout   time.Duration
+		wantCalls int
+		wantCode  int // 0 expects an error
+	}{
+		{"success", config, http.MethodGet, []int{200}, 0, 1, 200},
+		{"transient then success", config, http.MethodGet, []int{503, 0, 200}, 0, 3, 200},
+		{"gives up after max attempts", config, http.MethodGet, []int{500}, 0, 3, 500},
+		{"connection errors", config, http.MethodGet, []int{0}, 0, 3, 0},
+		{"client errors are final", config, http.MethodGet, []int{404}, 0, 1, 404},
+		{"non idempotent requests", config, http.MethodPut, []int{503, 200}, 0, 1, 503},
+		{"retries disabled", RetryConfig{MaxAttempts: 1}, http.MethodGet, []int{503, 200}, 0, 1, 503},
+		{
+			"no retry past the deadline",
+			RetryConfig{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+			http.MethodGet, []int{503, 200}, time.Second, 1, 503,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			req := httptest.NewRequest(tt.method, "https://prism:9440/api", nil).WithContext(ctx)
+			next, calls := sequence(tt.statuses...)
+
+			resp, err := tt.config.middleware(next)(req)
+			if *calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", *calls, tt.wantCalls)
+			}
+			switch {
+			case tt.wantCode == 0 && err == nil:
+				t.Errorf("status = %d, want an error", resp.StatusCode)
+			case tt.wantCode != 0 && err != nil:
+				t.Errorf("err = %v, want status %d", err, tt.wantCode)
+			case tt.wantCode != 0 && resp.StatusCode != tt.wantCode:
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
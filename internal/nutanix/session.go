@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+
+	"github.com/ingka-group/nutanix-exporter/internal/auth"
+)
+
+// APIKeyHeader is the header used by Prism to authenticate v4 API keys
+const APIKeyHeader = "X-Ntnx-Api-Key"
+
+// session holds the credentials for a Prism instance and the session cookies obtained with them.
+// It implements http.CookieJar so Prism session cookies are reused across requests;
+// Basic auth is only sent when no session has been established yet.
+type session struct {
+	mu    sync.RWMutex
+	creds auth.Credentials
+	jar   http.CookieJar
+}
+
+// newSession returns a new session for the given credentials
+func newSession(creds auth.Credentials) *session {
+	s := &session{}
+	s.setCredentials(creds)
+	return s
+}
+
+// newJar returns an empty cookie jar
+func newJar() http.CookieJar {
+	jar, _ := cookiejar.New(nil) // Never returns an error without options
+	return jar
+}
+
+// setCredentials replaces the credentials and drops the session established with the old ones
+func (s *session) setCredentials(creds auth.Credentials) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds = creds
+	s.jar = newJar()
+}
+
+// expire drops the current session so the next request authenticates again
+func (s *session) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jar = newJar()
+}
+
+// SetCookies implements http.CookieJar
+func (s *session) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.jar.SetCookies(u, cookies)
+}
+
+// Cookies implements http.CookieJar
+func (s *session) Cookies(u *url.URL) []*http.Cookie {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.jar.Cookies(u)
+}
+
+// authorize adds the authentication to the request
+// Uses the API key if configured, the session cookie if one exists and Basic auth otherwise
+func (s *session) authorize(req *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.creds.APIKey != "" {
+		req.Header.Set(APIKeyHeader, s.creds.APIKey)
+		return
+	}
+	if len(s.jar.Cookies(req.URL)) > 0 {
+		return
+	}
+	req.SetBasicAuth(s.creds.Username, s.creds.Password)
+}
+
+// usesSession reports whether the request relies on a session cookie rather than explicit credentials
+func usesSession(req *http.Request) bool {
+	_, _, basic := req.BasicAuth()
+	return !basic && req.Header.Get(APIKeyHeader) == ""
+}
+
+// middleware authorizes each request and re-authenticates once if the Prism session has expired
+func (s *session) middleware(next Doer) Doer {
+	return func(req *http.Request) (*http.Response, error) {
+		s.authorize(req)
+		resp, err := next(req)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || !usesSession(req) {
+			return resp, err
+		}
+
+		// The session has expired, drop it and retry with credentials
+		resp.Body.Close()
+		slog.Info("Session expired, re-authenticating", "host", req.URL.Host)
+		s.expire()
+
+		retry, err := rewind(req)
+		if err != nil {
+			return nil, err
+		}
+		s.authorize(retry)
+		return next(retry)
+	}
+}
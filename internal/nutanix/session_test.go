@@ -0,0 +1,168 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ingka-group/nutanix-exporter/internal/auth"
+)
+
+// prismStub accepts Basic auth for admin/secret and the API key "key", and hands out a session cookie
+type prismStub struct {
+	session string   // Currently valid session cookie
+	seen    []string // How each request authenticated: basic, cookie, key or none
+}
+
+func (p *prismStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		p.seen = append(p.seen, "key")
+		if key != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		return
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		p.seen = append(p.seen, "basic")
+		if user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "NTNX_IGW_SESSION", Value: p.session, Path: "/"})
+		return
+	}
+	cookie, err := r.Cookie("NTNX_IGW_SESSION")
+	if err != nil {
+		p.seen = append(p.seen, "none")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	p.seen = append(p.seen, "cookie")
+	if cookie.Value != p.session {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		creds      auth.Credentials
+		requests   int
+		expireFrom int // Request from which the server has rotated its session, 0 never
+		wantSeen   []string
+		wantStatus int // Status of the last request
+	}{
+		{
+			name:       "session cookie is reused",
+			creds:      auth.Credentials{Username: "admin", Password: "secret"},
+			requests:   3,
+			wantSeen:   []string{"basic", "cookie", "cookie"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "expired session re-authenticates once",
+			creds:      auth.Credentials{Username: "admin", Password: "secret"},
+			requests:   4,
+			expireFrom: 2,
+			wantSeen:   []string{"basic", "cookie", "cookie", "basic", "cookie"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "wrong credentials are not retried",
+			creds:      auth.Credentials{Username: "admin", Password: "wrong"},
+			requests:   1,
+			wantSeen:   []string{"basic"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "API key replaces the session",
+			creds:      auth.Credentials{APIKey: "key"},
+			requests:   2,
+			wantSeen:   []string{"key", "key"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "rejected API key is not retried",
+			creds:      auth.Credentials{Username: "admin", Password: "secret", APIKey: "revoked"},
+			requests:   1,
+			wantSeen:   []string{"key"},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &prismStub{session: "first"}
+			server := httptest.NewServer(stub)
+			defer server.Close()
+
+			s := newSession(tt.creds)
+			client := &http.Client{Jar: s}
+			do := s.middleware(client.Do)
+
+			var status int
+			for i := 0; i < tt.requests; i++ {
+				if tt.expireFrom > 0 && i == tt.expireFrom {
+					stub.session = "second"
+				}
+				req, _ := http.NewRequest(http.MethodGet, server.URL+"/api", nil)
+				resp, err := do(req)
+				if err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+				resp.Body.Close()
+				status = resp.StatusCode
+			}
+
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if len(stub.seen) != len(tt.wantSeen) {
+				t.Fatalf("requests authenticated with %v, want %v", stub.seen, tt.wantSeen)
+			}
+			for i := range tt.wantSeen {
+				if stub.seen[i] != tt.wantSeen[i] {
+					t.Fatalf("requests authenticated with %v, want %v", stub.seen, tt.wantSeen)
+				}
+			}
+		})
+	}
+}
+
+func TestSetCredentialsDropsSession(t *testing.T) {
+	stub := &prismStub{session: "first"}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	s := newSession(auth.Credentials{Username: "admin", Password: "secret"})
+	client := &http.Client{Jar: s}
+	do := s.middleware(client.Do)
+	for i := 0; i < 2; i++ {
+		if i == 1 {
+			s.setCredentials(auth.Credentials{Username: "admin", Password: "secret"})
+		}
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api", nil)
+		resp, err := do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if len(stub.seen) != 2 || stub.seen[1] != "basic" {
+		t.Errorf("requests authenticated with %v, want basic after new credentials", stub.seen)
+	}
+}
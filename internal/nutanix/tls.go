@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSConfig holds the TLS settings used to verify a Prism instance
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`              // PEM bundle added to the system roots
+	ServerName         string `yaml:"server_name"`          // Overrides the name verified in the certificate
+	MinVersion         string `yaml:"min_version"`          // 1.0, 1.1, 1.2 or 1.3, defaults to 1.2
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // Explicit opt-in to disable verification
+}
+
+// tlsVersions maps the configurable minimum versions to their crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Build returns the crypto/tls configuration for the settings
+func (t TLSConfig) Build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS min_version %q", t.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TransportConfig holds the tunables of the long-lived HTTP transport owned by each client
+type TransportConfig struct {
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"` // 0 means no limit
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	DisableHTTP2        bool          `yaml:"disable_http2"`
+}
+
+// ProxyConfig holds the proxy used to reach a Prism instance
+type ProxyConfig struct {
+	URL string `yaml:"url"` // http://, https:// or socks5:// proxy, empty uses HTTPS_PROXY and NO_PROXY
+}
+
+// proxyFunc returns the transport proxy function for the settings
+func (p ProxyConfig) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if p.URL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// ClientOptions holds the settings used to build a Prism API client
+type ClientOptions struct {
+	Timeout   time.Duration // Cap on every request, 0 leaves it to the request context
+	Transport TransportConfig
+	TLS       TLSConfig
+	Retry     RetryConfig
+	Breaker   BreakerConfig
+	RateLimit RateLimitConfig
+	Proxy     ProxyConfig
+}
+
+// DefaultTransportConfig returns the transport settings used when none are configured
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+// newTransport returns a pooled HTTP transport built from the client options
+func newTransport(opts ClientOptions) (*http.Transport, error) {
+	tlsConfig, err := opts.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := opts.Proxy.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          opts.Transport.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.Transport.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.Transport.MaxConnsPerHost,
+		IdleConnTimeout:       opts.Transport.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     !opts.Transport.DisableHTTP2,
+	}
+
+	if opts.Transport.DisableHTTP2 {
+		// A non-nil empty map disables the automatic HTTP/2 upgrade
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return transport, nil
+}
+
+// newHTTPClient returns the long-lived HTTP client shared by all requests of a Prism API client
+func newHTTPClient(opts ClientOptions, jar http.CookieJar) (*http.Client, error) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		Timeout:   opts.Timeout,
+	}, nil
+}
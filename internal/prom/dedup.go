@@ -0,0 +1,85 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prom
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ResultMaxAge is how long a fetched result is reused by later scrapes, 0 only shares in-flight fetches
+var ResultMaxAge time.Duration
+
+// fetchCall is a fetch in flight, shared by every scrape waiting for it
+type fetchCall struct {
+	done   chan struct{}
+	ctx    context.Context // Context of the scrape that started the fetch
+	result map[string]interface{}
+	err    error
+}
+
+// sharedFetch deduplicates concurrent fetches of a collector, singleflight-style,
+// and keeps the last successful result for reuse within ResultMaxAge
+type sharedFetch struct {
+	mu        sync.Mutex
+	call      *fetchCall
+	result    map[string]interface{}
+	fetchedAt time.Time
+}
+
+// do returns a recent result, joins the fetch in flight or starts a new one
+// The fetch runs with the context of the scrape that started it; waiting scrapes give up on their own context
+// and start the fetch again if the scrape that started it gave up first
+func (f *sharedFetch) do(ctx context.Context, fetch func(ctx context.Context) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	for {
+		f.mu.Lock()
+		if ResultMaxAge > 0 && f.result != nil && time.Since(f.fetchedAt) < ResultMaxAge {
+			result := f.result
+			f.mu.Unlock()
+			return result, nil
+		}
+
+		call := f.call
+		if call == nil {
+			call = &fetchCall{done: make(chan struct{}), ctx: ctx}
+			f.call = call
+			f.mu.Unlock()
+
+			call.result, call.err = fetch(ctx)
+
+			f.mu.Lock()
+			f.call = nil
+			if call.err == nil {
+				f.result, f.fetchedAt = call.result, time.Now()
+			}
+			f.mu.Unlock()
+			close(call.done)
+			return call.result, call.err
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-call.done:
+			if call.err != nil && call.ctx.Err() != nil && ctx.Err() == nil {
+				continue // The scrape that started the fetch gave up, this one still has time
+			}
+			return call.result, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
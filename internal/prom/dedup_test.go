@@ -0,0 +1,182 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSharedFetchConcurrent(t *testing.T) {
+	// Scrapes arriving after the fetch completed reuse its result, so the count does not depend on scheduling
+	defer func(old time.Duration) { ResultMaxAge = old }(ResultMaxAge)
+	ResultMaxAge = time.Minute
+
+	var f sharedFetch
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func(context.Context) (map[string]interface{}, error) {
+		calls.Add(1)
+		<-release
+		return map[string]interface{}{"ok": true}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan map[string]interface{}, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := f.do(context.Background(), fetch)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- result
+		}()
+	}
+	// Let every scrape join the fetch before it completes
+	for {
+		f.mu.Lock()
+		started := f.call != nil
+		f.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("fetches = %d, want 1", got)
+	}
+	for result := range results {
+		if result["ok"] != true {
+			t.Errorf("result = %v, want the shared result", result)
+		}
+	}
+}
+
+func TestSharedFetchReuse(t *testing.T) {
+	failure := errors.New("prism unavailable")
+	tests := []struct {
+		name      string
+		maxAge    time.Duration
+		errs      []error // Outcome of each sequential fetch
+		wantCalls int32
+	}{
+		{"in flight only", 0, []error{nil, nil, nil}, 3},
+		{"recent results are reused", time.Minute, []error{nil, nil, nil}, 1},
+		{"errors are not reused", time.Minute, []error{failure, failure, nil}, 3},
+		{"success after an error is reused", time.Minute, []error{failure, nil, nil}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(old time.Duration) { ResultMaxAge = old }(ResultMaxAge)
+			ResultMaxAge = tt.maxAge
+
+			var f sharedFetch
+			var calls atomic.Int32
+			for i, want := range tt.errs {
+				_, err := f.do(context.Background(), func(context.Context) (map[string]interface{}, error) {
+					n := calls.Add(1)
+					if err := tt.errs[n-1]; err != nil {
+						return nil, err
+					}
+					return map[string]interface{}{}, nil
+				})
+				if int(calls.Load()) == i+1 && !errors.Is(err, want) {
+					t.Errorf("scrape %d: err = %v, want %v", i, err, want)
+				}
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("fetches = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestSharedFetchWaiterContext(t *testing.T) {
+	var f sharedFetch
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go f.do(context.Background(), func(context.Context) (map[string]interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.do(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting scrape err = %v, want its own deadline", err)
+	}
+}
+
+func TestSharedFetchLeaderCancelled(t *testing.T) {
+	var f sharedFetch
+	var calls atomic.Int32
+	started := make(chan struct{})
+	fetch := func(ctx context.Context) (map[string]interface{}, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return nil, fmt.Errorf("fetch vms: %w", ctx.Err())
+		}
+		return map[string]interface{}{"ok": true}, nil
+	}
+
+	leader, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := f.do(leader, fetch)
+		leaderErr <- err
+	}()
+	<-started
+
+	// The second scraper of an HA pair joins the fetch, then the first one disconnects
+	waiter := make(chan error, 1)
+	var result map[string]interface{}
+	go func() {
+		var err error
+		result, err = f.do(context.Background(), fetch)
+		waiter <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled scrape err = %v, want context.Canceled", err)
+	}
+	if err := <-waiter; err != nil {
+		t.Fatalf("waiting scrape err = %v, want it to fetch on its own context", err)
+	}
+	if result["ok"] != true {
+		t.Errorf("waiting scrape result = %v, want its own fetch", result)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("fetches = %d, want 2", got)
+	}
+}
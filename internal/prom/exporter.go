@@ -19,7 +19,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"os"
 	"path/filepath"
@@ -29,6 +29,7 @@ import (
 	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"gopkg.in/yaml.v3"
 )
 
@@ -43,6 +44,7 @@ type Exporter struct {
 	Cluster *nutanix.Cluster                // Reference to the parent Cluster struct
 	Metrics map[string]*prometheus.GaugeVec // Holds the metrics defined by the exporter
 	Labels  []string                        // Common labels for the metrics
+	shared  sharedFetch                     // Deduplicates fetches of concurrent scrapes
 }
 
 // NewExporter is the constructor for Exporter
@@ -98,13 +100,30 @@ func (e *Exporter) flattenMap(prefix string, nestedMap map[string]interface{}) m
 	return flatMap
 }
 
-// Describe method required by prometheus.Collector interface
+// Describe method required by Collector interface
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	for _, gaugeVec := range e.Metrics {
 		gaugeVec.Describe(ch)
 	}
 }
 
+// update fetches the data at the given path, updates the metrics and sends them
+func (e *Exporter) update(ctx context.Context, ch chan<- prometheus.Metric, path string) error {
+	result, err := e.shared.do(ctx, func(ctx context.Context) (map[string]interface{}, error) {
+		return e.fetchData(ctx, path)
+	})
+	if err != nil {
+		return err
+	}
+
+	e.updateMetrics(result)
+
+	for _, gaugeVec := range e.Metrics {
+		gaugeVec.Collect(ch)
+	}
+	return nil
+}
+
 // fetchData makes a GET request to the given path and returns the response body as a map
 func (e *Exporter) fetchData(ctx context.Context, path string) (map[string]interface{}, error) {
 
@@ -121,7 +140,7 @@ func (e *Exporter) fetchData(ctx context.Context, path string) (map[string]inter
 	if resp.StatusCode == 403 || resp.StatusCode == 401 {
 		e.Cluster.Mutex.Lock()
 		if !e.Cluster.RefreshNeeded {
-			log.Printf("Marking stale credentials for refresh for cluster %s", e.Cluster.Name)
+			slog.Warn("Marking stale credentials for refresh", "cluster", e.Cluster.Name)
 			e.Cluster.RefreshNeeded = true
 		}
 		e.Cluster.Mutex.Unlock()
@@ -132,23 +151,46 @@ func (e *Exporter) fetchData(ctx context.Context, path string) (map[string]inter
 
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Error decoding response body: %v\n", err)
+		slog.Error("Error decoding response body", "cluster", e.Cluster.Name, "path", path, "err", err)
 		return nil, err
 	}
 
 	return result, nil
 }
 
-// initMetrics initializes metrics based on the provided config file and labels.
-func (e *Exporter) initMetrics(configPath string, labelNames []string) error {
+// LoadMetricConfig reads and validates the metric config file at the given path
+func LoadMetricConfig(configPath string) ([]MetricConfig, error) {
 	yamlFile, err := os.ReadFile(configPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var metrics []MetricConfig
-	err = yaml.Unmarshal(yamlFile, &metrics)
+	if err := yaml.Unmarshal(yamlFile, &metrics); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	// Use the filename without extension as the subsystem
+	subsystem := strings.TrimSuffix(filepath.Base(configPath), filepath.Ext(configPath))
+
+	seen := make(map[string]bool, len(metrics))
+	for _, m := range metrics {
+		if !model.IsValidMetricName(model.LabelValue(prometheus.BuildFQName("nutanix", subsystem, m.Name))) {
+			return nil, fmt.Errorf("invalid metric name %q in %s", m.Name, configPath)
+		}
+		if seen[m.Name] {
+			return nil, fmt.Errorf("duplicate metric %q in %s", m.Name, configPath)
+		}
+		seen[m.Name] = true
+	}
+	return metrics, nil
+}
+
+// initMetrics initializes metrics based on the provided config file and labels.
+func (e *Exporter) initMetrics(configPath string, labelNames []string) error {
+	metrics, err := LoadMetricConfig(configPath)
 	if err != nil {
+		slog.Error("Failed to load metric config", "err", err)
 		return err
 	}
 
@@ -17,8 +17,6 @@ package prom
 
 import (
 	"context"
-	"log"
-	"time"
 
 	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
 
@@ -81,76 +79,24 @@ func NewStorageContainerCollector(cluster *nutanix.Cluster, configPath string) *
 	return exporter
 }
 
-// ----- Collect Methods ----- //
+// ----- Update Methods ----- //
 
-// Collect
-func (e *StorageContainerExporter) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	result, err := e.fetchData(ctx, "/v2.0/storage_containers/")
-	if err != nil {
-		log.Printf("Error fetching storage container data: %v", err)
-		return
-	}
-
-	e.updateMetrics(result)
-
-	for _, gaugeVec := range e.Metrics {
-		gaugeVec.Collect(ch)
-	}
+// Update
+func (e *StorageContainerExporter) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return e.update(ctx, ch, "/v2.0/storage_containers/")
 }
 
-// Collect
-func (e *ClusterExporter) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	result, err := e.fetchData(ctx, "/v2.0/cluster/")
-	if err != nil {
-		log.Printf("Error fetching cluster data: %v", err)
-		return
-	}
-
-	e.updateMetrics(result)
-
-	for _, gaugeVec := range e.Metrics {
-		gaugeVec.Collect(ch)
-	}
+// Update
+func (e *ClusterExporter) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return e.update(ctx, ch, "/v2.0/cluster/")
 }
 
-// Collect
-func (e *HostsExporter) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	result, err := e.fetchData(ctx, "/v2.0/hosts/")
-	if err != nil {
-		log.Printf("Error fetching host data: %v", err)
-		return
-	}
-
-	e.updateMetrics(result)
-
-	for _, gaugeVec := range e.Metrics {
-		gaugeVec.Collect(ch)
-	}
+// Update
+func (e *HostsExporter) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return e.update(ctx, ch, "/v2.0/hosts/")
 }
 
-// Collect
-func (e *VmExporter) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	result, err := e.fetchData(ctx, "/v2.0/vms/")
-	if err != nil {
-		log.Printf("Error fetching VM data: %v", err)
-		return
-	}
-
-	e.updateMetrics(result)
-
-	for _, gaugeVec := range e.Metrics {
-		gaugeVec.Collect(ch)
-	}
+// Update
+func (e *VmExporter) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return e.update(ctx, ch, "/v2.0/vms/")
 }
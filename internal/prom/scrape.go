@@ -0,0 +1,205 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prom
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultTimeout bounds a scrape that has no deadline of its own
+const DefaultTimeout = 10 * time.Second
+
+// Scrape status metrics, reported per scrape
+var (
+	upDesc = prometheus.NewDesc(
+		"nutanix_up",
+		"Whether the cluster could be scraped, 0 if every collector failed.",
+		[]string{"cluster_name"}, nil,
+	)
+	collectorSuccessDesc = prometheus.NewDesc(
+		"nutanix_scrape_collector_success",
+		"Whether the collector succeeded in this scrape.",
+		[]string{"cluster_name", "collector"}, nil,
+	)
+	collectorDurationDesc = prometheus.NewDesc(
+		"nutanix_scrape_collector_duration_seconds",
+		"Duration of the collector in this scrape.",
+		[]string{"cluster_name", "collector"}, nil,
+	)
+	collectorLastSuccessDesc = prometheus.NewDesc(
+		"nutanix_collector_last_success_timestamp_seconds",
+		"Unix timestamp of the last successful scrape of the collector.",
+		[]string{"cluster_name", "collector"}, nil,
+	)
+)
+
+// Collector is implemented by every exporter run by the ScrapeCollector
+type Collector interface {
+	Describe(ch chan<- *prometheus.Desc)
+	Update(ctx context.Context, ch chan<- prometheus.Metric) error
+}
+
+// ScrapeCollector runs the collectors of a cluster concurrently and reports
+// the cluster and per collector scrape status, in the style of node_exporter
+type ScrapeCollector struct {
+	Cluster    *nutanix.Cluster
+	Collectors map[string]Collector
+	Timeouts   map[string]time.Duration // Optional upper bound per collector
+	status     *collectorStatus
+}
+
+// collectorStatus holds the last successful scrape of each collector
+// It is shared by a ScrapeCollector and its filtered copies
+type collectorStatus struct {
+	mu          sync.Mutex
+	lastSuccess map[string]time.Time
+}
+
+// NewScrapeCollector is the constructor for ScrapeCollector
+func NewScrapeCollector(cluster *nutanix.Cluster, collectors map[string]Collector) *ScrapeCollector {
+	return &ScrapeCollector{
+		Cluster:    cluster,
+		Collectors: collectors,
+		Timeouts:   make(map[string]time.Duration),
+		status:     &collectorStatus{lastSuccess: make(map[string]time.Time)},
+	}
+}
+
+// Filter returns a ScrapeCollector running only the named collectors
+// The collectors themselves are shared, so concurrent scrapes still share their Prism calls
+func (s *ScrapeCollector) Filter(names []string) *ScrapeCollector {
+	collectors := make(map[string]Collector, len(names))
+	for _, name := range names {
+		if c, ok := s.Collectors[name]; ok {
+			collectors[name] = c
+		}
+	}
+	return &ScrapeCollector{
+		Cluster:    s.Cluster,
+		Collectors: collectors,
+		Timeouts:   s.Timeouts,
+		status:     s.status,
+	}
+}
+
+// Describe method required by prometheus.Collector interface
+func (s *ScrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+	ch <- collectorSuccessDesc
+	ch <- collectorDurationDesc
+	ch <- collectorLastSuccessDesc
+	for _, c := range s.Collectors {
+		c.Describe(ch)
+	}
+}
+
+// Collect method required by prometheus.Collector interface
+// Collects with DefaultTimeout, use WithContext to bind the collection to a scrape
+func (s *ScrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.collect(context.Background(), ch)
+}
+
+// boundScrapeCollector is a ScrapeCollector whose collection is bound to a context
+type boundScrapeCollector struct {
+	*ScrapeCollector
+	ctx context.Context
+}
+
+// WithContext returns a collector that cancels in-flight Prism calls when the context is done
+func (s *ScrapeCollector) WithContext(ctx context.Context) prometheus.Collector {
+	return &boundScrapeCollector{ScrapeCollector: s, ctx: ctx}
+}
+
+// Collect method required by prometheus.Collector interface
+func (b *boundScrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	b.collect(b.ctx, ch)
+}
+
+// collect runs every collector concurrently until they finish or the context is done
+func (s *ScrapeCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	up := 0.0
+
+	for name, c := range s.Collectors {
+		wg.Add(1)
+		go func(name string, c Collector) {
+			defer wg.Done()
+			if s.execute(ctx, name, c, ch) {
+				mu.Lock()
+				up = 1
+				mu.Unlock()
+			}
+		}(name, c)
+	}
+	wg.Wait()
+
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, up, s.Cluster.Name)
+}
+
+// execute runs a single collector and sends its status, reporting whether it succeeded
+func (s *ScrapeCollector) execute(ctx context.Context, name string, c Collector, ch chan<- prometheus.Metric) bool {
+	if timeout := s.Timeouts[name]; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := c.Update(ctx, ch)
+	duration := time.Since(start)
+
+	success := 0.0
+	if err != nil {
+		slog.Error("Error fetching data", "collector", name, "cluster", s.Cluster.Name, "err", err)
+	} else {
+		success = 1
+		s.status.mu.Lock()
+		s.status.lastSuccess[name] = start
+		s.status.mu.Unlock()
+	}
+
+	ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, success, s.Cluster.Name, name)
+	ch <- prometheus.MustNewConstMetric(collectorDurationDesc, prometheus.GaugeValue, duration.Seconds(), s.Cluster.Name, name)
+
+	s.status.mu.Lock()
+	lastSuccess, ok := s.status.lastSuccess[name]
+	s.status.mu.Unlock()
+	if ok {
+		ch <- prometheus.MustNewConstMetric(collectorLastSuccessDesc, prometheus.GaugeValue, float64(lastSuccess.Unix()), s.Cluster.Name, name)
+	}
+
+	return err == nil
+}
+
+// KeepStatus carries the collector status of a replaced scrape collector over,
+// so the last success timestamps survive a reload
+func (s *ScrapeCollector) KeepStatus(old *ScrapeCollector) {
+	s.status = old.status
+}